@@ -1,13 +1,18 @@
 package app
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"oauth-go/internal/services"
 	"oauth-go/internal/store"
 	"oauth-go/internal/types"
 	"oauth-go/pkg/database"
+	"oauth-go/pkg/fakeidp"
+	"slices"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -51,16 +56,92 @@ func New(config *types.AppConfig, logger *slog.Logger) (*App, error) {
 		DB:       0,
 	})
 
+	if config.ProfileSyncPolicy != types.ProfileSyncAlways && config.ProfileSyncPolicy != types.ProfileSyncFillEmpty {
+		return nil, fmt.Errorf("unknown PROFILE_SYNC_POLICY %s", config.ProfileSyncPolicy)
+	}
+
+	if !slices.Contains(types.LoginDeliveryModes, config.LoginDelivery) {
+		return nil, fmt.Errorf("unknown LOGIN_DELIVERY %s", config.LoginDelivery)
+	}
+
+	if config.UpstreamTimeout < 1 || config.UpstreamRetries < 0 || config.UpstreamBreakerThreshold < 1 {
+		return nil, fmt.Errorf("UPSTREAM_TIMEOUT and UPSTREAM_BREAKER_THRESHOLD must be positive, UPSTREAM_RETRIES must not be negative")
+	}
+
+	if config.OAuthIssuer == "" {
+		config.OAuthIssuer = "http://" + net.JoinHostPort(config.AppHost, config.AppPort) + OAuthPath
+	}
+
+	config.OAuthIssuer = strings.TrimSuffix(config.OAuthIssuer, "/")
+
+	tokenKey, err := base64.StdEncoding.DecodeString(config.TokenEncryptionKey)
+
+	if err != nil || len(tokenKey) != 32 {
+		return nil, fmt.Errorf("TOKEN_ENCRYPTION_KEY must be base64 encoded 32 bytes key")
+	}
+
 	app.Store = &store.Store{
-		User:    store.NewUserStore(app.DB),
-		Session: store.NewSessionStore(app.DB),
+		User:         store.NewUserStore(app.DB),
+		Identity:     store.NewIdentityStore(app.DB),
+		Token:        store.NewTokenStore(app.DB, tokenKey),
+		Session:      store.NewSessionStore(app.DB),
+		OAuthState:   store.NewOAuthStateStore(app.RDB),
+		ExchangeCode: store.NewExchangeCodeStore(app.RDB),
+
+		OAuthClient:       store.NewOAuthClientStore(app.DB),
+		AuthorizationCode: store.NewAuthorizationCodeStore(app.RDB),
+		SSOSession:        store.NewSSOSessionStore(app.RDB),
+		DeviceCode:        store.NewDeviceCodeStore(app.RDB),
+		RevokedToken:      store.NewRevokedTokenStore(app.RDB),
 	}
 
-	app.Services = services.New(app.Config)
+	app.Services, err = services.New(app.Config, app.Logger, app.RDB)
+
+	if err != nil {
+		return nil, err
+	}
 
 	return app, nil
 }
 
+// OAuthPath is where authorization server endpoints are served
+const OAuthPath = "/api/v1/oauth"
+
+// DevIDPPath is where fake identity provider is mounted when DEV_IDP_ENABLED is set
+const DevIDPPath = "/dev/idp"
+
+// MountDevIDP serves fake identity provider with fixture users from DEV_IDP_USERS_FILE
+func (app *App) MountDevIDP() error {
+	var users []fakeidp.User
+
+	if app.Config.DevIDPUsersFile != "" {
+		loaded, err := fakeidp.LoadUsers(app.Config.DevIDPUsersFile)
+
+		if err != nil {
+			return err
+		}
+
+		users = loaded
+	}
+
+	issuer := app.Config.DevIDPIssuer
+
+	if issuer == "" {
+		issuer = "http://" + net.JoinHostPort(app.Config.AppHost, app.Config.AppPort) + DevIDPPath
+	}
+
+	idp, err := fakeidp.New(issuer, users)
+
+	if err != nil {
+		return err
+	}
+
+	app.Router.Any(DevIDPPath+"/*path", gin.WrapH(http.StripPrefix(DevIDPPath, idp.Handler())))
+	app.Logger.Warn("fake identity provider is enabled, never enable it in production", "issuer", issuer)
+
+	return nil
+}
+
 func (app *App) Start() error {
 	return app.Router.Run(net.JoinHostPort(app.Config.AppHost, app.Config.AppPort))
 }
@@ -1,25 +1,54 @@
 package controllers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/derenko404/ipapi-go"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
 
 	"oauth-go/internal/app"
 	"oauth-go/internal/middleware"
+	eventsservice "oauth-go/internal/services/events"
+	jwtservice "oauth-go/internal/services/jwt"
+	ouathservice "oauth-go/internal/services/oauth"
 	"oauth-go/internal/store"
+	"oauth-go/internal/types"
 	"oauth-go/pkg/cookieutils"
 	"oauth-go/pkg/response"
 )
 
 var (
 	DeviceIdCookieName = "device_id"
+	OAuthStateTTL      = 15 * time.Minute
+	// state binding cookie ties oauth state to browser which started sign-in
+	StateBindingCookieName = "oauth_binding"
+	StateBindingCookiePath = "/api/v1/auth"
 )
 
+// errAccountExists is returned when account with the same email exists
+// but email is not verified on both sides, so accounts cannot be merged
+var errAccountExists = errors.New("account with the same email exists")
+
+// errEmailRequired is returned when new identity has no email,
+// accounts are never created or matched without one
+var errEmailRequired = errors.New("provider profile has no email")
+
 type authController struct {
 	app *app.App
 }
@@ -45,8 +74,60 @@ func getDeviceID(ctx *gin.Context) string {
 	return deviceID
 }
 
+// hashBinding returns sha256 hex of state binding, only hashes are stored
+func hashBinding(binding string) string {
+	hash := sha256.Sum256([]byte(binding))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// bindState returns hash of browser state binding, the cookie is reused
+// so concurrent sign-ins in several tabs share it. SameSite=None lets
+// cross-site form_post callbacks (apple) send it
+func bindState(ctx *gin.Context) (string, error) {
+	binding, err := ctx.Cookie(StateBindingCookieName)
+
+	if err != nil || binding == "" {
+		binding, err = generateRandomString(32)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     StateBindingCookieName,
+		Value:    binding,
+		Path:     StateBindingCookiePath,
+		MaxAge:   int(OAuthStateTTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	return hashBinding(binding), nil
+}
+
+// verifyStateBinding checks that request carries binding cookie of the browser
+// which started sign-in, requests without the cookie are rejected
+func verifyStateBinding(ctx *gin.Context, bindingHash string) bool {
+	binding, err := ctx.Cookie(StateBindingCookieName)
+
+	if err != nil || binding == "" || bindingHash == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashBinding(binding)), []byte(bindingHash)) == 1
+}
+
 func getLocation(ip string) (string, error) {
 	location := "Unknown, Unknown"
+
+	// local addresses have no location, don't ask ipapi about them
+	if addr, err := netip.ParseAddr(ip); err == nil && (addr.IsLoopback() || addr.IsPrivate()) {
+		return location, nil
+	}
+
 	resp, err := ipapi.GetIpLocation(ip)
 
 	if err != nil {
@@ -56,25 +137,183 @@ func getLocation(ip string) (string, error) {
 	return fmt.Sprintf("%s, %s", resp.CountryName, resp.City), nil
 }
 
-func generateState(secret []byte) (string, error) {
-	claims := jwt.MapClaims{
-		"exp": time.Now().Add(time.Minute * 15).Unix(), // 15 min expiry
+func generateRandomString(size int) (string, error) {
+	bytes := make([]byte, size)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// parseScopes splits space or comma separated scopes
+func parseScopes(scope string) []string {
+	return strings.FieldsFunc(scope, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
 }
 
-func validateState(jwtSecret []byte, tokenString string) bool {
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Check that the signing method is HMAC (HS256)
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
+// isAllowedReturnURL checks that url is absolute and matches one of allowlisted
+// urls by scheme, host and path prefix, so sign-in cannot be used as open redirect
+func isAllowedReturnURL(returnTo string, allowed []string) bool {
+	target, err := url.Parse(returnTo)
+
+	if err != nil || !target.IsAbs() || target.User != nil {
+		return false
+	}
+
+	for _, item := range allowed {
+		allowedURL, err := url.Parse(item)
+
+		if err != nil {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(allowedURL.Path, "/")
+
+		if target.Scheme == allowedURL.Scheme &&
+			target.Host == allowedURL.Host &&
+			(target.Path == prefix || strings.HasPrefix(target.Path, prefix+"/")) {
+			return true
 		}
-		return jwtSecret, nil
+	}
+
+	return false
+}
+
+// signInRequest holds sign-in options chosen by frontend
+type signInRequest struct {
+	ReturnTo string
+	// extra scopes requested on top of provider defaults
+	Scopes   []string
+	Delivery string
+	// name of registered provider redirect url
+	Redirect string
+	// set when authenticated user links new identity instead of signing in
+	LinkUserID int
+	// S256 challenge which binds exchange code of delivery=code to frontend
+	ExchangeChallenge string
+}
+
+// parseSignInRequest reads sign-in options from query, return_to must be allowlisted
+// and redirect delivery modes require it
+func parseSignInRequest(app *app.App, ctx *gin.Context) (*signInRequest, *response.APIError) {
+	request := &signInRequest{
+		ReturnTo: ctx.Query("return_to"),
+		Scopes:   parseScopes(ctx.Query("scope")),
+		Delivery: ctx.DefaultQuery("delivery", app.Config.LoginDelivery),
+		Redirect: ctx.Query("redirect"),
+
+		ExchangeChallenge: ctx.Query("code_challenge"),
+	}
+
+	if request.ReturnTo != "" && !isAllowedReturnURL(request.ReturnTo, app.Config.AllowedReturnURLs) {
+		app.Logger.Info("return_to is not allowed", "return_to", request.ReturnTo)
+		return nil, response.ErrInvalidInput
+	}
+
+	if !slices.Contains(types.LoginDeliveryModes, request.Delivery) {
+		app.Logger.Info("unknown delivery mode", "delivery", request.Delivery)
+		return nil, response.ErrInvalidInput
+	}
+
+	if request.ExchangeChallenge != "" && (request.Delivery != types.LoginDeliveryCode || !isValidCodeChallenge(request.ExchangeChallenge)) {
+		app.Logger.Info("code_challenge is allowed only as S256 challenge with code delivery")
+		return nil, response.ErrInvalidInput
+	}
+
+	// redirect delivery modes send browser back to frontend
+	if request.Delivery != types.LoginDeliveryJSON && request.ReturnTo == "" {
+		app.Logger.Info("return_to is required for delivery mode", "delivery", request.Delivery)
+		return nil, response.ErrInvalidInput
+	}
+
+	return request, nil
+}
+
+// startSignIn saves one-time oauth state and returns provider sign-in url
+func startSignIn(app *app.App, ctx *gin.Context, provider string, request *signInRequest) (string, *response.APIError) {
+	if err := app.Services.OAuth.IsSupported(provider); err != nil {
+		app.Logger.Info("unsupported provider", "provider", provider)
+		return "", response.ErrOAuth
+	}
+
+	if err := app.Services.OAuth.ValidateScopes(provider, request.Scopes); err != nil {
+		app.Logger.Info("requested scopes are not allowed", "provider", provider, "error", err)
+		return "", response.ErrInvalidInput
+	}
+
+	redirectURL, err := app.Services.OAuth.RedirectURL(provider, request.Redirect)
+
+	if err != nil {
+		app.Logger.Info("redirect is not registered", "provider", provider, "error", err)
+		return "", response.ErrInvalidInput
+	}
+
+	state, err := generateRandomString(32)
+
+	if err != nil {
+		app.Logger.Error("cannot generate state", "error", err)
+		return "", response.ErrOAuth
+	}
+
+	nonce, err := generateRandomString(32)
+
+	if err != nil {
+		app.Logger.Error("cannot generate nonce", "error", err)
+		return "", response.ErrOAuth
+	}
+
+	bindingHash, err := bindState(ctx)
+
+	if err != nil {
+		app.Logger.Error("cannot generate state binding", "error", err)
+		return "", response.ErrOAuth
+	}
+
+	oauthState := &store.OAuthState{
+		Provider:     provider,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		ReturnTo:     request.ReturnTo,
+		Scopes:       request.Scopes,
+		DeviceID:     getDeviceID(ctx),
+		BindingHash:  bindingHash,
+		LinkUserID:   request.LinkUserID,
+		Delivery:     request.Delivery,
+		RedirectURL:  redirectURL,
+		CreatedAt:    time.Now(),
+
+		ExchangeChallenge: request.ExchangeChallenge,
+	}
+
+	err = app.Store.OAuthState.CreateState(ctx.Request.Context(), state, oauthState, OAuthStateTTL)
+
+	if err != nil {
+		app.Logger.Error("cannot save oauth state", "error", err)
+		return "", response.ErrInternalServerError
+	}
+
+	url, err := app.Services.OAuth.GetSignInUrl(ctx.Request.Context(), provider, &ouathservice.AuthRequest{
+		State:        state,
+		Nonce:        oauthState.Nonce,
+		CodeVerifier: oauthState.CodeVerifier,
+		Scopes:       oauthState.Scopes,
+		RedirectURL:  oauthState.RedirectURL,
 	})
 
-	return err == nil
+	if errors.Is(err, ouathservice.ErrProviderUnavailable) {
+		app.Logger.Warn("provider is unavailable", "provider", provider, "error", err)
+		return "", response.ErrProviderUnavailable
+	}
+
+	if err != nil {
+		app.Logger.Error("error during sign-in", "error", err)
+		return "", response.ErrOAuth
+	}
+
+	return url, nil
 }
 
 type signInResponse struct {
@@ -86,26 +325,28 @@ type signInResponse struct {
 // @Tags        auth
 // @Accept      json
 // @Produce     json
-// @Param       provider path string true "Selected provider, available options: google, github"
+// @Param       provider path string true "Selected provider, one of enabled OAUTH_PROVIDERS, e.g. google, github"
+// @Param       return_to query string false "Frontend url to return to after sign-in, must be allowlisted in ALLOWED_RETURN_URLS"
+// @Param       scope query string false "Extra space separated scopes, must be allowlisted in <PROVIDER>_ALLOWED_SCOPES"
+// @Param       redirect query string false "Name of registered provider redirect url from <PROVIDER>_REDIRECT_URLS, <PROVIDER>_REDIRECT_URL when empty"
+// @Param       delivery query string false "How tokens are delivered after callback: json, code, fragment or cookie, defaults to LOGIN_DELIVERY"
+// @Param       code_challenge query string false "S256 challenge of verifier sent to /auth/exchange, delivery=code only"
 // @Success     200 {object} response.APISuccessResponse{data=signInResponse}
 // @Failure     400 {object} response.APIErrorResponse
+// @Failure     503 {object} response.APIErrorResponse
 // @Router      /auth/sign-in/{provider} [get]
 func (controller *authController) SignIn(ctx *gin.Context) {
-	provider := ctx.Param("provider")
+	request, apiErr := parseSignInRequest(controller.app, ctx)
 
-	state, err := generateState([]byte(controller.app.Config.JwtSecret))
-
-	if err != nil {
-		controller.app.Logger.Error("cannot generate state", "error", err)
-		response.RespondError(ctx, response.ErrOAuth)
+	if apiErr != nil {
+		response.RespondError(ctx, apiErr)
 		return
 	}
 
-	url, err := controller.app.Services.OAuth.GetSignInUrl(provider, state)
+	url, apiErr := startSignIn(controller.app, ctx, ctx.Param("provider"), request)
 
-	if err != nil {
-		controller.app.Logger.Error("error during sign-in", "error", err)
-		response.RespondError(ctx, response.ErrOAuth)
+	if apiErr != nil {
+		response.RespondError(ctx, apiErr)
 		return
 	}
 
@@ -114,10 +355,349 @@ func (controller *authController) SignIn(ctx *gin.Context) {
 	})
 }
 
+// syncIdentityEmail stores email verification status reported by provider on each login
+func (controller *authController) syncIdentityEmail(ctx context.Context, identity *store.UserIdentity, profile *ouathservice.ProfileImpl) error {
+	if identity.Email != nil && *identity.Email == profile.Email && identity.EmailVerified == profile.EmailVerified {
+		return nil
+	}
+
+	return controller.app.Store.Identity.UpdateIdentityEmail(ctx, identity.ID, profile.Email, profile.EmailVerified)
+}
+
+type profileFieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+type userProfileUpdatedEvent struct {
+	UserID   int                           `json:"user_id"`
+	Provider string                        `json:"provider"`
+	Changes  map[string]profileFieldChange `json:"changes"`
+}
+
+// syncProfile updates provider sourced user fields according to PROFILE_SYNC_POLICY
+// and publishes change event when any value differs
+func (controller *authController) syncProfile(ctx context.Context, user *store.User, provider string, profile *ouathservice.ProfileImpl) (*store.User, error) {
+	overwrite := controller.app.Config.ProfileSyncPolicy == types.ProfileSyncAlways
+	changes := map[string]profileFieldChange{}
+	dto := &store.UpdateUserDto{}
+
+	syncField := func(field string, current *string, value string) *string {
+		if value == "" || (current != nil && *current == value) {
+			return nil
+		}
+
+		if current != nil && *current != "" && !overwrite {
+			return nil
+		}
+
+		change := profileFieldChange{New: value}
+
+		if current != nil {
+			change.Old = *current
+		}
+
+		changes[field] = change
+
+		return &value
+	}
+
+	dto.Name = syncField("name", user.Name, profile.Name)
+	dto.AvatarURL = syncField("avatar_url", user.AvatarURL, profile.AvatarURL)
+
+	// verification belongs to the email, so it is synced regardless of policy. It is only
+	// upgraded, provider which does not report verification can't unverify the email
+	if user.Email == profile.Email && !user.IsEmailVerified && profile.EmailVerified {
+		dto.IsEmailVerified = &profile.EmailVerified
+		changes["is_email_verified"] = profileFieldChange{Old: user.IsEmailVerified, New: profile.EmailVerified}
+	}
+
+	if len(changes) == 0 {
+		return user, nil
+	}
+
+	user, err := controller.app.Store.User.UpdateUser(ctx, user.ID, dto)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = controller.app.Services.Events.Publish(ctx, eventsservice.UserProfileUpdated, &userProfileUpdatedEvent{
+		UserID:   user.ID,
+		Provider: provider,
+		Changes:  changes,
+	})
+
+	if err != nil {
+		controller.app.Logger.Error("cannot publish profile update event", "user_id", user.ID, "error", err)
+	}
+
+	return user, nil
+}
+
+// mergeUserClaims sets user roles to union of roles of all linked identities,
+// so signing in with another provider does not take roles away
+func mergeUserClaims(app *app.App, ctx context.Context, user *store.User) (*store.User, error) {
+	identities, err := app.Store.Identity.GetIdentitiesBy(ctx, map[string]any{"user_id": user.ID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	attributes := map[string]string{}
+
+	// identities are ordered by link time, attributes of earlier linked identity win
+	for _, identity := range slices.Backward(identities) {
+		for _, role := range identity.Roles {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+
+		maps.Copy(attributes, identity.Attributes)
+	}
+
+	slices.Sort(roles)
+
+	if slices.Equal(user.Roles, roles) && maps.Equal(user.Attributes, attributes) {
+		return user, nil
+	}
+
+	return app.Store.User.UpdateUser(ctx, user.ID, &store.UpdateUserDto{
+		Roles:      roles,
+		Attributes: attributes,
+	})
+}
+
+// syncIdentityClaims stores roles and attributes granted by claims mapping rules
+// to identity used to sign in and merges claims of all identities into user
+func syncIdentityClaims(app *app.App, ctx context.Context, user *store.User, identity *store.UserIdentity, profile *ouathservice.ProfileImpl) (*store.User, error) {
+	if !app.Services.Claims.Enabled() {
+		return user, nil
+	}
+
+	roles, attributes := app.Services.Claims.Map(identity.Provider, profile)
+
+	if !slices.Equal(identity.Roles, roles) || !maps.Equal(identity.Attributes, attributes) {
+		err := app.Store.Identity.UpdateIdentityClaims(ctx, identity.ID, roles, attributes)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mergeUserClaims(app, ctx, user)
+}
+
+// syncClaims updates claims of identity used for current login
+func (controller *authController) syncClaims(ctx context.Context, user *store.User, provider string, profile *ouathservice.ProfileImpl) (*store.User, error) {
+	if !controller.app.Services.Claims.Enabled() {
+		return user, nil
+	}
+
+	identity, err := controller.app.Store.Identity.GetIdentityBy(ctx, map[string]any{
+		"provider":         provider,
+		"provider_user_id": profile.ID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return syncIdentityClaims(controller.app, ctx, user, identity, profile)
+}
+
+// userClaims returns jwt claims of user session
+func userClaims(user *store.User, sessionID int) jwtservice.AppCustomClaims {
+	return jwtservice.AppCustomClaims{
+		UserID:     user.ID,
+		Email:      user.Email,
+		SessionID:  sessionID,
+		Roles:      user.Roles,
+		Attributes: user.Attributes,
+	}
+}
+
+// findOrCreateUser resolves user by provider identity first,
+// falls back to user with the same verified email and links identity to it,
+// creates new user when nothing matches. New identities without email are refused
+func (controller *authController) findOrCreateUser(ctx context.Context, provider string, profile *ouathservice.ProfileImpl) (*store.User, error) {
+	filters := map[string]any{
+		"provider":         provider,
+		"provider_user_id": profile.ID,
+	}
+
+	identity, err := controller.app.Store.Identity.GetIdentityBy(ctx, filters)
+
+	if err == nil {
+		user, err := controller.app.Store.User.GetUserBy(ctx, map[string]any{"id": identity.UserID})
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = controller.syncIdentityEmail(ctx, identity, profile)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot sync identity email: %w", err)
+		}
+
+		return user, nil
+	}
+
+	if !errors.Is(err, store.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if profile.Email == "" {
+		return nil, errEmailRequired
+	}
+
+	user, err := controller.app.Store.User.GetUserBy(ctx, map[string]any{"email": profile.Email})
+
+	if err == nil {
+		// merging by unverified email would let anyone who registers
+		// the victim email at some provider to take over the account
+		if !profile.EmailVerified || !user.IsEmailVerified {
+			return nil, errAccountExists
+		}
+
+		_, err = controller.app.Store.Identity.CreateIdentity(ctx, &store.UserIdentityDto{
+			UserID:         user.ID,
+			Provider:       provider,
+			ProviderUserID: profile.ID,
+			Email:          profile.Email,
+			EmailVerified:  profile.EmailVerified,
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot link identity: %w", err)
+		}
+
+		controller.app.Logger.Info("identity linked by email", "user_id", user.ID, "provider", provider)
+
+		return user, nil
+	}
+
+	// database failure must not end up in duplicate account
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	return controller.app.Store.User.CreateUser(ctx, &store.UserDto{
+		Name:            profile.Name,
+		Email:           profile.Email,
+		AvatarURL:       profile.AvatarURL,
+		Provider:        provider,
+		ProviderUserID:  profile.ID,
+		IsEmailVerified: profile.EmailVerified,
+	})
+}
+
+// createSession returns existing session of the device or creates new one
+func (controller *authController) createSession(ctx *gin.Context, user *store.User, deviceID string) (*store.UserSession, error) {
+	clientIP := ctx.ClientIP()
+	location, err := getLocation(clientIP)
+
+	if err != nil {
+		controller.app.Logger.Info("cannot get location for", "ip", clientIP, "error", err.Error())
+	}
+
+	filters := map[string]any{
+		"user_id":   user.ID,
+		"device_id": deviceID,
+	}
+
+	// @TODO
+	// add session version
+	// increase it each time when user logs in to invalidate old tokens
+	// issued for that session
+	session, err := controller.app.Store.Session.GetSessionBy(ctx.Request.Context(), filters)
+
+	if err != nil {
+		session, err = controller.app.Store.Session.CreateSession(ctx.Request.Context(), &store.UserSessionDto{
+			UserID:    user.ID,
+			IPAddress: clientIP,
+			UserAgent: ctx.GetHeader("User-Agent"),
+			Location:  location,
+			DeviceID:  deviceID,
+		})
+	}
+
+	return session, err
+}
+
+// profileError maps errors of provider profile verification to api errors
+func (controller *authController) profileError(provider string, err error) *response.APIError {
+	switch {
+	case errors.Is(err, ouathservice.ErrNoVerifiedEmail):
+		controller.app.Logger.Info("provider account has no verified email", "provider", provider)
+		return response.ErrNoVerifiedEmail
+	case errors.Is(err, ouathservice.ErrTenantNotAllowed):
+		controller.app.Logger.Info("sign-in from not allowed tenant", "provider", provider, "error", err)
+		return response.ErrTenantNotAllowed
+	case errors.Is(err, ouathservice.ErrAccessDenied):
+		controller.app.Logger.Info("sign-in rejected by admission rules", "provider", provider, "error", err)
+		return response.ErrAccessDenied
+	case errors.Is(err, ouathservice.ErrProviderUnavailable):
+		controller.app.Logger.Warn("provider is unavailable", "provider", provider, "error", err)
+		return response.ErrProviderUnavailable
+	default:
+		controller.app.Logger.Error("cannot get profile", "provider", provider, "error", err)
+		return response.ErrOAuth
+	}
+}
+
+// signIn finds or creates user of provider profile, syncs provider data
+// and returns session of the device
+func (controller *authController) signIn(ctx *gin.Context, provider string, profile *ouathservice.ProfileImpl, deviceID string) (*store.User, *store.UserSession, *response.APIError) {
+	user, err := controller.findOrCreateUser(ctx.Request.Context(), provider, profile)
+
+	if errors.Is(err, errAccountExists) {
+		controller.app.Logger.Info("refused to merge accounts by unverified email", "provider", provider)
+		return nil, nil, response.ErrAccountExists
+	}
+
+	if errors.Is(err, errEmailRequired) {
+		controller.app.Logger.Info("refused to create account without email", "provider", provider)
+		return nil, nil, response.ErrNoVerifiedEmail
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("failed to get or create user", "error", err)
+		return nil, nil, response.ErrInternalServerError
+	}
+
+	user, err = controller.syncProfile(ctx.Request.Context(), user, provider, profile)
+
+	if err != nil {
+		controller.app.Logger.Error("failed to sync user profile", "error", err)
+		return nil, nil, response.ErrInternalServerError
+	}
+
+	user, err = controller.syncClaims(ctx.Request.Context(), user, provider, profile)
+
+	if err != nil {
+		controller.app.Logger.Error("failed to sync user claims", "error", err)
+		return nil, nil, response.ErrInternalServerError
+	}
+
+	session, err := controller.createSession(ctx, user, deviceID)
+
+	if err != nil {
+		controller.app.Logger.Error("failed to create session", "error", err)
+		return nil, nil, response.ErrInternalServerError
+	}
+
+	return user, session, nil
+}
+
 type handleCallbackResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	DeviceID     string `json:"device_id"`
+	ReturnTo     string `json:"return_to,omitempty"`
 }
 
 // @Summary		Endpoint for OAuth providers
@@ -125,107 +705,198 @@ type handleCallbackResponse struct {
 // @Tags			  auth
 // @Accept			json
 // @Produce		  json
-// @Param state path string true "OAuth state string"
-// @Param code path string true "OAuth code"
+// @Param state query string true "OAuth state string"
+// @Param code query string true "OAuth code"
+// @Param user formData string false "User json, posted by apple with response_mode=form_post on first authorization"
 // @Success     200 {object} response.APISuccessResponse{data=handleCallbackResponse}
+// @Success     302 "Redirect to return_to when delivery is code, fragment or cookie"
 // @Failure		  400	{object} response.APIErrorResponse
+// @Failure		  403	{object} response.APIErrorResponse
 // @Failure		  422	{object} response.APIErrorResponse
 // @Failure		  500	{object} response.APIErrorResponse
+// @Failure		  503	{object} response.APIErrorResponse
 // @Router			/auth/handle-callback [get]
+// @Router			/auth/handle-callback [post]
 func (controller *authController) HandleCallback(ctx *gin.Context) {
 	provider := ctx.Param("provider")
 
+	// providers with response_mode=form_post send parameters in form body
 	var query struct {
 		State string `form:"state" binding:"required"`
 		Code  string `form:"code" binding:"required"`
+		User  string `form:"user"`
 	}
 
-	if err := ctx.ShouldBindQuery(&query); err != nil {
+	if err := ctx.ShouldBind(&query); err != nil {
 		controller.app.Logger.Error("error binding query", "error", err)
 		response.RespondError(ctx, response.ErrInvalidInput)
 		return
 	}
 
-	valid := validateState([]byte(controller.app.Config.JwtSecret), query.State)
+	oauthState, err := controller.app.Store.OAuthState.ConsumeState(ctx.Request.Context(), query.State)
 
-	if !valid {
-		controller.app.Logger.Info("invalid oauth state")
+	if err != nil {
+		controller.app.Logger.Info("invalid oauth state", "error", err)
 		response.RespondError(ctx, response.ErrInvalidInput)
 		return
 	}
 
-	profile, err := controller.app.Services.OAuth.GetProfile(ctx.Request.Context(), provider, query.Code)
+	if oauthState.Provider != provider {
+		controller.app.Logger.Info("oauth state issued for another provider", "provider", provider, "state_provider", oauthState.Provider)
+		respondCallbackError(ctx, oauthState, response.ErrInvalidInput)
+		return
+	}
 
-	if err != nil {
-		controller.app.Logger.Error("cannot get profile", "error", err)
-		response.RespondError(ctx, response.ErrOAuth)
+	// state must be completed by the same browser which started sign-in,
+	// otherwise attacker could sign victim in to attacker account (login csrf)
+	if !verifyStateBinding(ctx, oauthState.BindingHash) {
+		controller.app.Logger.Info("oauth state is not bound to this browser")
+		respondCallbackError(ctx, oauthState, response.ErrInvalidInput)
 		return
 	}
 
-	var user *store.User
+	authRequest := &ouathservice.AuthRequest{
+		State:        query.State,
+		Nonce:        oauthState.Nonce,
+		CodeVerifier: oauthState.CodeVerifier,
+		Scopes:       oauthState.Scopes,
+		User:         query.User,
+		RedirectURL:  oauthState.RedirectURL,
+	}
 
-	filters := map[string]any{"email": profile.Email}
-	user, err = controller.app.Store.User.GetUserBy(ctx.Request.Context(), filters)
+	profile, providerToken, err := controller.app.Services.OAuth.GetProfile(ctx.Request.Context(), provider, query.Code, authRequest)
 
 	if err != nil {
-		controller.app.Logger.Error("cannot get user", "error", err)
+		respondCallbackError(ctx, oauthState, controller.profileError(provider, err))
+		return
+	}
 
-		user, err = controller.app.Store.User.CreateUser(ctx.Request.Context(), &store.UserDto{
-			Name:           profile.Name,
-			Email:          profile.Email,
-			AvatarURL:      profile.AvatarURL,
-			Provider:       provider,
-			ProviderUserID: profile.ID,
-		})
+	if oauthState.LinkUserID != 0 {
+		identity, apiErr := linkIdentity(controller.app, ctx.Request.Context(), oauthState.LinkUserID, provider, profile)
+
+		if apiErr != nil {
+			respondCallbackError(ctx, oauthState, apiErr)
+			return
+		}
+
+		user, err := controller.app.Store.User.GetUserBy(ctx.Request.Context(), map[string]any{"id": oauthState.LinkUserID})
+
+		if err == nil {
+			_, err = syncIdentityClaims(controller.app, ctx.Request.Context(), user, identity, profile)
+		}
+
+		if err != nil {
+			// identity is linked, its roles are granted on next login
+			controller.app.Logger.Error("cannot sync claims of linked identity", "user_id", oauthState.LinkUserID, "error", err)
+		}
+
+		err = saveProviderToken(controller.app, ctx.Request.Context(), provider, profile, providerToken, authRequest)
 
 		if err != nil {
-			controller.app.Logger.Error("failed to create user", "error", err)
-			response.RespondError(ctx, response.ErrInternalServerError)
+			controller.app.Logger.Error("cannot save provider token", "provider", provider, "error", err)
+		}
+
+		// linking does not issue tokens, browser is just sent back
+		if isRedirectDelivery(oauthState) {
+			ctx.Redirect(http.StatusFound, oauthState.ReturnTo)
 			return
 		}
+
+		response.RespondSuccess(ctx, &linkIdentityResponse{
+			Identity: identity,
+			ReturnTo: oauthState.ReturnTo,
+		})
+		return
 	}
 
-	clientIP := ctx.ClientIP()
-	location, err := getLocation(clientIP)
+	deviceID := oauthState.DeviceID
+
+	user, session, apiErr := controller.signIn(ctx, provider, profile, deviceID)
+
+	if apiErr != nil {
+		respondCallbackError(ctx, oauthState, apiErr)
+		return
+	}
+
+	err = saveProviderToken(controller.app, ctx.Request.Context(), provider, profile, providerToken, authRequest)
 
 	if err != nil {
-		controller.app.Logger.Info("cannot get location for", "ip", clientIP, "error", err.Error())
+		// user still can sign in, only calls to provider api on their behalf are affected
+		controller.app.Logger.Error("cannot save provider token", "provider", provider, "error", err)
 	}
 
-	deviceID := getDeviceID(ctx)
+	controller.app.Logger.Info("user signed in", "user", user, "session", session)
 
-	filters = map[string]any{
-		"user_id":   user.ID,
-		"device_id": deviceID,
+	controller.deliverTokens(ctx, oauthState, profile, user, session)
+}
+
+type idTokenSignInRequest struct {
+	IDToken string `json:"id_token" binding:"required"`
+	// raw nonce passed to provider sdk, apple sdk gets its sha256 hash
+	Nonce string `json:"nonce" binding:"required"`
+	// apple returns user name to the app on first authorization only
+	Name     string `json:"name"`
+	DeviceID string `json:"device_id"`
+}
+
+type idTokenSignInResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	DeviceID     string `json:"device_id"`
+}
+
+// @Summary		Sign In with ID token
+// @Description	Signs in with id token returned to native apps by provider sdk, e.g. google or apple
+// @Tags			  auth
+// @Accept			json
+// @Produce		  json
+// @Param       provider path string true "Provider supporting id token sign-in, e.g. google, apple"
+// @Param       request body idTokenSignInRequest true "Provider id token"
+// @Success     200 {object} response.APISuccessResponse{data=idTokenSignInResponse}
+// @Failure		  400	{object} response.APIErrorResponse
+// @Failure		  403	{object} response.APIErrorResponse
+// @Failure		  500	{object} response.APIErrorResponse
+// @Failure		  503	{object} response.APIErrorResponse
+// @Router			/auth/token/{provider} [post]
+func (controller *authController) SignInWithIDToken(ctx *gin.Context) {
+	provider := ctx.Param("provider")
+
+	var req idTokenSignInRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.RespondError(ctx, response.ErrInvalidInput)
+		return
 	}
 
-	// @TODO
-	// add session version
-	// increase it each time when user logs in to invalidate old tokens
-	// issued for that session
-	session, err := controller.app.Store.Session.GetSessionBy(ctx.Request.Context(), filters)
+	profile, err := controller.app.Services.OAuth.VerifyIDToken(ctx.Request.Context(), provider, req.IDToken, req.Nonce)
 
 	if err != nil {
-		session, err = controller.app.Store.Session.CreateSession(ctx.Request.Context(), &store.UserSessionDto{
-			UserID:    user.ID,
-			IPAddress: clientIP,
-			UserAgent: ctx.GetHeader("User-Agent"),
-			Location:  location,
-			DeviceID:  deviceID,
-		})
+		response.RespondError(ctx, controller.profileError(provider, err))
+		return
+	}
 
-		if err != nil {
-			controller.app.Logger.Error("failed to create session", "error", err)
-			response.RespondError(ctx, response.ErrInternalServerError)
-			return
-		}
+	if profile.Name == "" {
+		profile.Name = req.Name
 	}
 
-	accessToken, refreshToken := controller.app.Services.Jwt.IssueTokensPair(user.ID, session.ID, user.Email)
+	deviceID := req.DeviceID
 
-	controller.app.Logger.Info("user signed in", "user", user, "session", session)
+	if deviceID == "" {
+		deviceID = getDeviceID(ctx)
+	}
+
+	user, session, apiErr := controller.signIn(ctx, provider, profile, deviceID)
+
+	if apiErr != nil {
+		response.RespondError(ctx, apiErr)
+		return
+	}
 
-	response.RespondSuccess(ctx, &handleCallbackResponse{
+	accessToken, refreshToken := controller.app.Services.Jwt.IssueTokensPair(userClaims(user, session.ID))
+
+	controller.app.Logger.Info("user signed in with id token", "user", user, "session", session)
+
+	response.RespondSuccess(ctx, &idTokenSignInResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		DeviceID:     deviceID,
@@ -258,8 +929,9 @@ func (controller *authController) GetMe(ctx *gin.Context) {
 	response.RespondSuccess(ctx, &getMeResponse{User: user})
 }
 
+// refresh token is read from cookie when it is not sent in body
 type refreshTokenRequest struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 type refreshTokenResponse struct {
@@ -279,10 +951,18 @@ type refreshTokenResponse struct {
 // @Router			/auth/refresh [post]
 func (controller *authController) RefreshToken(ctx *gin.Context) {
 	var req refreshTokenRequest
+	fromCookie := false
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		response.RespondError(ctx, response.ErrInvalidInput)
-		return
+	if err := ctx.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		cookie, cookieErr := ctx.Cookie(RefreshTokenCookieName)
+
+		if cookieErr != nil || cookie == "" {
+			response.RespondError(ctx, response.ErrInvalidInput)
+			return
+		}
+
+		req.RefreshToken = cookie
+		fromCookie = true
 	}
 
 	token, err := controller.app.Services.Jwt.VerifyToken(req.RefreshToken)
@@ -310,7 +990,27 @@ func (controller *authController) RefreshToken(ctx *gin.Context) {
 		return
 	}
 
-	accessToken, refreshToken := controller.app.Services.Jwt.IssueTokensPair(claims.UserID, session.ID, claims.Email)
+	// roles and attributes are read from user, so changes of mapped claims
+	// and unlinked identities take effect on refresh
+	user, err := controller.app.Store.User.GetUserBy(ctx.Request.Context(), map[string]any{"id": claims.UserID})
+
+	if errors.Is(err, store.ErrNotFound) {
+		controller.app.Logger.Info("refresh token of deleted user", "user_id", claims.UserID)
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("error during request processing", "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	accessToken, refreshToken := controller.app.Services.Jwt.IssueTokensPair(userClaims(user, session.ID))
+
+	if fromCookie {
+		setAuthCookies(ctx, accessToken, refreshToken)
+	}
 
 	response.RespondSuccess(ctx, &refreshTokenResponse{
 		AccessToken:  accessToken,
@@ -350,5 +1050,42 @@ func (controller *authController) SignOut(ctx *gin.Context) {
 		return
 	}
 
+	deleteAuthCookies(ctx)
+
 	response.RespondSuccess(ctx, &signOutResponse{})
 }
+
+type deleteMeResponse struct{}
+
+// @Summary		Delete Account
+// @Description	Deletes current user, its sessions, linked identities and stored provider tokens
+// @Tags			  auth
+// @Security BearerAuth
+// @Accept			json
+// @Produce		  json
+// @Success     200 {object} response.APISuccessResponse
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  500	{object} response.APIErrorResponse
+// @Router			/auth/me [delete]
+func (controller *authController) DeleteMe(ctx *gin.Context) {
+	user, err := middleware.MustGetUserFromContext(ctx)
+
+	if err != nil {
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	err = controller.app.Store.User.DeleteUser(ctx.Request.Context(), user.ID)
+
+	if err != nil {
+		controller.app.Logger.Error("error deleting user", "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	controller.app.Logger.Info("user deleted", "user_id", user.ID)
+
+	deleteAuthCookies(ctx)
+
+	response.RespondSuccess(ctx, &deleteMeResponse{})
+}
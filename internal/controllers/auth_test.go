@@ -0,0 +1,480 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+
+	"oauth-go/internal/app"
+	"oauth-go/internal/middleware"
+	"oauth-go/internal/services"
+	claimsservice "oauth-go/internal/services/claims"
+	eventsservice "oauth-go/internal/services/events"
+	issuerservice "oauth-go/internal/services/issuer"
+	jwtservice "oauth-go/internal/services/jwt"
+	ouathservice "oauth-go/internal/services/oauth"
+	"oauth-go/internal/store"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/fakeidp"
+)
+
+const (
+	testProvider    = "testidp"
+	testCallbackURL = "https://app.example.com/api/v1/auth/callback/" + testProvider
+)
+
+// newTestApp creates app with in-memory stores and routes used by tests,
+// testidp provider is configured when issuer of fake identity provider is set
+func newTestApp(t *testing.T, issuer string) (*app.App, *memoryDB) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	if issuer != "" {
+		t.Setenv("TESTIDP_TYPE", "oidc")
+		t.Setenv("TESTIDP_CLIENT_ID", "oauth-go")
+		t.Setenv("TESTIDP_CLIENT_SECRET", "secret")
+		t.Setenv("TESTIDP_REDIRECT_URL", testCallbackURL)
+		t.Setenv("TESTIDP_ISSUER_URL", issuer)
+	}
+
+	config := &types.AppConfig{
+		JwtSecret:                "secret",
+		LoginDelivery:            types.LoginDeliveryJSON,
+		ProfileSyncPolicy:        types.ProfileSyncFillEmpty,
+		OAuthIssuer:              "https://app.example.com/api/v1/oauth",
+		OAuthProviders:           []string{testProvider},
+		UpstreamTimeout:          5,
+		UpstreamRetries:          0,
+		UpstreamBreakerThreshold: 5,
+		UpstreamBreakerCooldown:  30,
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	claims, err := claimsservice.New(config)
+
+	if err != nil {
+		t.Fatalf("cannot create claims service: %v", err)
+	}
+
+	issuerService, err := issuerservice.New(config, logger)
+
+	if err != nil {
+		t.Fatalf("cannot create issuer service: %v", err)
+	}
+
+	// nothing listens there, publishing events fails and is only logged
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+
+	memoryStore, db := newMemoryStore()
+
+	testApp := &app.App{
+		Config: config,
+		Logger: logger,
+		Router: gin.New(),
+		Store:  memoryStore,
+		RDB:    rdb,
+		Services: &services.Services{
+			OAuth:  ouathservice.New(config, logger),
+			Jwt:    jwtservice.New(config),
+			Events: eventsservice.New(rdb),
+			Claims: claims,
+			Issuer: issuerService,
+		},
+	}
+
+	authController := NewAuthController(testApp)
+	oauthController := NewOAuthController(testApp)
+	authMiddleware := middleware.AuthMiddleware(testApp.Store, testApp.Services, testApp.Logger)
+
+	api := testApp.Router.Group("/api/v1")
+
+	api.GET("/auth/sign-in/:provider", authController.SignIn)
+	api.GET("/auth/callback/:provider", authController.HandleCallback)
+	api.POST("/auth/refresh", authController.RefreshToken)
+	api.GET("/auth/me", authMiddleware, authController.GetMe)
+
+	api.POST("/oauth/token", oauthController.Token)
+	api.POST("/oauth/device_authorization", oauthController.DeviceAuthorization)
+
+	return testApp, db
+}
+
+// serve sends request to app router from local address, so no location lookup is made
+func serve(testApp *app.App, req *http.Request) *httptest.ResponseRecorder {
+	req.RemoteAddr = "127.0.0.1:12345"
+
+	recorder := httptest.NewRecorder()
+	testApp.Router.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func decodeBody(t *testing.T, recorder *httptest.ResponseRecorder, out any) {
+	t.Helper()
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+		t.Fatalf("cannot decode response %s: %v", recorder.Body.String(), err)
+	}
+}
+
+// startTestSignIn starts sign-in and returns state and binding cookie
+// with code issued by fake identity provider to user
+func startTestSignIn(t *testing.T, testApp *app.App, user string) (url.Values, *http.Cookie) {
+	t.Helper()
+
+	recorder := serve(testApp, httptest.NewRequest(http.MethodGet, "/api/v1/auth/sign-in/"+testProvider, nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("sign-in responded %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var signIn struct {
+		Data signInResponse `json:"data"`
+	}
+
+	decodeBody(t, recorder, &signIn)
+
+	var binding *http.Cookie
+
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == StateBindingCookieName {
+			binding = cookie
+		}
+	}
+
+	if binding == nil {
+		t.Fatal("state binding cookie is not set")
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(signIn.Data.URL + "&user=" + user)
+
+	if err != nil {
+		t.Fatalf("cannot authorize at identity provider: %v", err)
+	}
+
+	resp.Body.Close()
+
+	location, err := resp.Location()
+
+	if err != nil {
+		t.Fatalf("identity provider did not redirect: %v", err)
+	}
+
+	if !strings.HasPrefix(location.String(), testCallbackURL+"?") {
+		t.Fatalf("identity provider redirected to %s", location)
+	}
+
+	return location.Query(), binding
+}
+
+// tamperState changes oauth state saved by sign-in
+func tamperState(t *testing.T, db *memoryDB, state string, tamper func(state *store.OAuthState)) {
+	t.Helper()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var data store.OAuthState
+
+	if !db.get("state:"+state, &data) {
+		t.Fatalf("state %s is not saved", state)
+	}
+
+	tamper(&data)
+
+	if err := db.set("state:"+state, &data); err != nil {
+		t.Fatalf("cannot save state: %v", err)
+	}
+}
+
+func callback(testApp *app.App, query url.Values, binding *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/callback/"+testProvider+"?"+query.Encode(), nil)
+
+	if binding != nil {
+		req.AddCookie(binding)
+	}
+
+	return serve(testApp, req)
+}
+
+// startTestIDP starts fake identity provider with default users
+func startTestIDP(t *testing.T) string {
+	t.Helper()
+
+	idp, err := fakeidp.NewTestServer(nil)
+
+	if err != nil {
+		t.Fatalf("cannot start identity provider: %v", err)
+	}
+
+	t.Cleanup(idp.Close)
+
+	return idp.URL
+}
+
+// callbackTest changes callback request or oauth state saved by sign-in
+type callbackTest struct {
+	name   string
+	tamper func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie)
+	valid  bool
+}
+
+func runCallbackTests(t *testing.T, tests []callbackTest) {
+	issuer := startTestIDP(t)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testApp, db := newTestApp(t, issuer)
+
+			query, binding := startTestSignIn(t, testApp, "1")
+			query, binding = test.tamper(t, db, query, binding)
+
+			recorder := callback(testApp, query, binding)
+
+			if test.valid && recorder.Code != http.StatusOK {
+				t.Fatalf("callback responded %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			if !test.valid && recorder.Code == http.StatusOK {
+				t.Fatalf("callback succeeded: %s", recorder.Body.String())
+			}
+
+			if !test.valid && len(db.sessions) != 0 {
+				t.Fatal("session is created")
+			}
+		})
+	}
+}
+
+func TestSignInCallbackPKCE(t *testing.T) {
+	runCallbackTests(t, []callbackTest{
+		{
+			name: "verifier of sign-in",
+			tamper: func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie) {
+				return query, binding
+			},
+			valid: true,
+		},
+		{
+			name: "another verifier",
+			tamper: func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie) {
+				tamperState(t, db, query.Get("state"), func(state *store.OAuthState) { state.CodeVerifier = oauth2.GenerateVerifier() })
+				return query, binding
+			},
+		},
+		{
+			name: "without verifier",
+			tamper: func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie) {
+				tamperState(t, db, query.Get("state"), func(state *store.OAuthState) { state.CodeVerifier = "" })
+				return query, binding
+			},
+		},
+	})
+}
+
+func TestSignInCallbackState(t *testing.T) {
+	runCallbackTests(t, []callbackTest{
+		{
+			name: "without binding cookie",
+			tamper: func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie) {
+				return query, nil
+			},
+		},
+		{
+			name: "binding cookie of another browser",
+			tamper: func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie) {
+				return query, &http.Cookie{Name: StateBindingCookieName, Value: "another"}
+			},
+		},
+		{
+			name: "unknown state",
+			tamper: func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie) {
+				query.Set("state", "unknown")
+				return query, binding
+			},
+		},
+		{
+			name: "without state",
+			tamper: func(t *testing.T, db *memoryDB, query url.Values, binding *http.Cookie) (url.Values, *http.Cookie) {
+				query.Del("state")
+				return query, binding
+			},
+		},
+	})
+}
+
+func TestSignInCallbackStateIsSingleUse(t *testing.T) {
+	testApp, _ := newTestApp(t, startTestIDP(t))
+
+	query, binding := startTestSignIn(t, testApp, "1")
+
+	if recorder := callback(testApp, query, binding); recorder.Code != http.StatusOK {
+		t.Fatalf("callback responded %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := callback(testApp, query, binding); recorder.Code == http.StatusOK {
+		t.Fatal("callback with used state succeeded")
+	}
+}
+
+// signInTestUser signs in user of fake identity provider and returns tokens of callback
+func signInTestUser(t *testing.T, testApp *app.App, user string) handleCallbackResponse {
+	t.Helper()
+
+	query, binding := startTestSignIn(t, testApp, user)
+	recorder := callback(testApp, query, binding)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("callback responded %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var tokens struct {
+		Data handleCallbackResponse `json:"data"`
+	}
+
+	decodeBody(t, recorder, &tokens)
+
+	if tokens.Data.AccessToken == "" || tokens.Data.RefreshToken == "" {
+		t.Fatalf("tokens are not issued: %s", recorder.Body.String())
+	}
+
+	return tokens.Data
+}
+
+func TestRefreshTokenClaims(t *testing.T) {
+	issuer := startTestIDP(t)
+
+	tests := []struct {
+		name string
+		// change updates user after sign-in
+		change func(db *memoryDB)
+		status int
+		roles  []string
+	}{
+		{
+			name:   "roles granted after sign-in",
+			change: func(db *memoryDB) { db.users[0].Roles = []string{"admin"} },
+			status: http.StatusOK,
+			roles:  []string{"admin"},
+		},
+		{
+			name: "roles removed after sign-in",
+			change: func(db *memoryDB) {
+				db.users[0].Roles = nil
+				db.users[0].Attributes = nil
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "deleted user",
+			change: func(db *memoryDB) { db.users = nil },
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testApp, db := newTestApp(t, issuer)
+			refreshToken := signInTestUser(t, testApp, "1").RefreshToken
+
+			// role granted by mapping rules before refresh is carried by refreshed tokens
+			db.mu.Lock()
+			db.users[0].Roles = []string{"support"}
+			db.mu.Unlock()
+
+			recorder := refresh(testApp, refreshToken)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("refresh responded %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			var refreshed struct {
+				Data refreshTokenResponse `json:"data"`
+			}
+
+			decodeBody(t, recorder, &refreshed)
+
+			db.mu.Lock()
+			test.change(db)
+			db.mu.Unlock()
+
+			recorder = refresh(testApp, refreshed.Data.RefreshToken)
+
+			if recorder.Code != test.status {
+				t.Fatalf("refresh responded %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			decodeBody(t, recorder, &refreshed)
+
+			token, err := testApp.Services.Jwt.VerifyToken(refreshed.Data.AccessToken)
+
+			if err != nil {
+				t.Fatalf("invalid access token: %v", err)
+			}
+
+			claims, err := testApp.Services.Jwt.GetClaims(token)
+
+			if err != nil || !slices.Equal(claims.Roles, test.roles) {
+				t.Fatalf("expected roles %v, got %+v", test.roles, claims)
+			}
+		})
+	}
+}
+
+func refresh(testApp *app.App, refreshToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	return serve(testApp, req)
+}
+
+func TestSignInWithFakeIDP(t *testing.T) {
+	testApp, _ := newTestApp(t, startTestIDP(t))
+	tokens := signInTestUser(t, testApp, "1")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	recorder := serve(testApp, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("me responded %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var me struct {
+		Data struct {
+			User struct {
+				Email           string `json:"email"`
+				IsEmailVerified bool   `json:"is_email_verified"`
+			} `json:"user"`
+		} `json:"data"`
+	}
+
+	decodeBody(t, recorder, &me)
+
+	if me.Data.User.Email != "alice@example.com" || !me.Data.User.IsEmailVerified {
+		t.Fatalf("unexpected user %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"oauth-go/internal/middleware"
+	ouathservice "oauth-go/internal/services/oauth"
+	"oauth-go/internal/store"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/cookieutils"
+	"oauth-go/pkg/response"
+)
+
+var (
+	RefreshTokenCookieName = "refresh_token"
+	// refresh token cookie is sent only to auth endpoints
+	RefreshTokenCookiePath = "/api/v1/auth"
+	ExchangeCodeTTL        = time.Minute
+)
+
+// setAuthCookies stores tokens in HttpOnly cookies, SameSite=Strict
+// keeps them from being sent with cross-site requests
+func setAuthCookies(ctx *gin.Context, accessToken string, refreshToken string) {
+	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(middleware.AccessTokenCookieName, accessToken, cookieutils.OneHour, "/", "", true, true)
+	ctx.SetCookie(RefreshTokenCookieName, refreshToken, 7*cookieutils.OneDay, RefreshTokenCookiePath, "", true, true)
+}
+
+func deleteAuthCookies(ctx *gin.Context) {
+	if _, err := ctx.Cookie(middleware.AccessTokenCookieName); err == nil {
+		ctx.SetCookie(middleware.AccessTokenCookieName, "", -1, "/", "", true, true)
+	}
+
+	if _, err := ctx.Cookie(RefreshTokenCookieName); err == nil {
+		ctx.SetCookie(RefreshTokenCookieName, "", -1, RefreshTokenCookiePath, "", true, true)
+	}
+}
+
+// withQuery returns url with query parameters added
+func withQuery(rawURL string, params url.Values) string {
+	target, err := url.Parse(rawURL)
+
+	if err != nil {
+		return rawURL
+	}
+
+	query := target.Query()
+
+	for key, values := range params {
+		query[key] = values
+	}
+
+	target.RawQuery = query.Encode()
+
+	return target.String()
+}
+
+// withFragment returns url with fragment replaced by encoded parameters
+func withFragment(rawURL string, params url.Values) string {
+	target, err := url.Parse(rawURL)
+
+	if err != nil {
+		return rawURL
+	}
+
+	target.Fragment = ""
+	target.RawFragment = ""
+
+	return target.String() + "#" + params.Encode()
+}
+
+// isRedirectDelivery reports whether callback redirects browser back to return_to
+func isRedirectDelivery(oauthState *store.OAuthState) bool {
+	return oauthState.Delivery != "" && oauthState.Delivery != types.LoginDeliveryJSON && oauthState.ReturnTo != ""
+}
+
+// respondCallbackError redirects browser to return_to with error code
+// when sign-in uses redirect delivery, otherwise responds with json error
+func respondCallbackError(ctx *gin.Context, oauthState *store.OAuthState, apiErr *response.APIError) {
+	if !isRedirectDelivery(oauthState) {
+		response.RespondError(ctx, apiErr)
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, withQuery(oauthState.ReturnTo, url.Values{"error": {apiErr.Message}}))
+}
+
+// deliverTokens completes sign-in according to delivery mode chosen on sign-in,
+// tokens are never put into query string
+func (controller *authController) deliverTokens(ctx *gin.Context, oauthState *store.OAuthState, profile *ouathservice.ProfileImpl, user *store.User, session *store.UserSession) {
+	// sign-in started by /oauth/authorize continues there, tokens are issued to oauth client
+	if oauthState.Delivery == types.LoginDeliverySSO {
+		err := startSSOSession(controller.app, ctx, oauthState.Provider, profile.AMR, user, session)
+
+		if err != nil {
+			controller.app.Logger.Error("cannot start sso session", "error", err)
+			respondCallbackError(ctx, oauthState, response.ErrInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, oauthState.ReturnTo)
+		return
+	}
+
+	if oauthState.Delivery == types.LoginDeliveryCode {
+		code, err := generateRandomString(32)
+
+		if err == nil {
+			err = controller.app.Store.ExchangeCode.CreateCode(ctx.Request.Context(), code, &store.ExchangeCode{
+				UserID:    user.ID,
+				SessionID: session.ID,
+				DeviceID:  oauthState.DeviceID,
+				CreatedAt: time.Now(),
+
+				CodeChallenge: oauthState.ExchangeChallenge,
+				BindingHash:   oauthState.BindingHash,
+			}, ExchangeCodeTTL)
+		}
+
+		if err != nil {
+			controller.app.Logger.Error("cannot create exchange code", "error", err)
+			respondCallbackError(ctx, oauthState, response.ErrInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, withQuery(oauthState.ReturnTo, url.Values{"code": {code}}))
+		return
+	}
+
+	accessToken, refreshToken := controller.app.Services.Jwt.IssueTokensPair(userClaims(user, session.ID))
+
+	switch oauthState.Delivery {
+	case types.LoginDeliveryFragment:
+		ctx.Redirect(http.StatusFound, withFragment(oauthState.ReturnTo, url.Values{
+			"access_token":  {accessToken},
+			"refresh_token": {refreshToken},
+			"token_type":    {"Bearer"},
+			"device_id":     {oauthState.DeviceID},
+		}))
+	case types.LoginDeliveryCookie:
+		setAuthCookies(ctx, accessToken, refreshToken)
+		ctx.Redirect(http.StatusFound, oauthState.ReturnTo)
+	default:
+		response.RespondSuccess(ctx, &handleCallbackResponse{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+			DeviceID:     oauthState.DeviceID,
+			ReturnTo:     oauthState.ReturnTo,
+		})
+	}
+}
+
+// isExchangeCodeBound checks PKCE verifier when frontend sent code_challenge
+// on sign-in, otherwise state binding cookie of browser is required
+func isExchangeCodeBound(ctx *gin.Context, exchangeCode *store.ExchangeCode, verifier string) bool {
+	if exchangeCode.CodeChallenge == "" {
+		return verifyStateBinding(ctx, exchangeCode.BindingHash)
+	}
+
+	challenge := oauth2.S256ChallengeFromVerifier(verifier)
+
+	return verifier != "" && subtle.ConstantTimeCompare([]byte(challenge), []byte(exchangeCode.CodeChallenge)) == 1
+}
+
+type exchangeCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+	// verifier of code_challenge sent on sign-in
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type exchangeCodeResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	DeviceID     string `json:"device_id"`
+}
+
+// @Summary		Exchange Code
+// @Description	Exchanges one-time code from sign-in redirect with delivery=code for tokens
+// @Tags			  auth
+// @Accept			json
+// @Produce		  json
+// @Param       request body exchangeCodeRequest true "One-time code from return_to url"
+// @Success     200 {object} response.APISuccessResponse{data=exchangeCodeResponse}
+// @Failure		  400	{object} response.APIErrorResponse
+// @Failure		  401	{object} response.APIErrorResponse
+// @Router			/auth/exchange [post]
+func (controller *authController) ExchangeCode(ctx *gin.Context) {
+	var req exchangeCodeRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.RespondError(ctx, response.ErrInvalidInput)
+		return
+	}
+
+	exchangeCode, err := controller.app.Store.ExchangeCode.ConsumeCode(ctx.Request.Context(), req.Code)
+
+	if err != nil {
+		controller.app.Logger.Info("invalid exchange code", "error", err)
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	// code leaked from logs, history or referer is useless without
+	// verifier of frontend or binding cookie of browser which signed in
+	if !isExchangeCodeBound(ctx, exchangeCode, req.CodeVerifier) {
+		controller.app.Logger.Info("exchange code is not bound to this client")
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	user, err := controller.app.Store.User.GetUserBy(ctx.Request.Context(), map[string]any{"id": exchangeCode.UserID})
+
+	if err != nil {
+		controller.app.Logger.Info("cannot get user of exchange code", "error", err)
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	session, err := controller.app.Store.Session.GetSessionBy(ctx.Request.Context(), map[string]any{"id": exchangeCode.SessionID})
+
+	if err != nil {
+		controller.app.Logger.Info("cannot get session of exchange code", "error", err)
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	accessToken, refreshToken := controller.app.Services.Jwt.IssueTokensPair(userClaims(user, session.ID))
+
+	response.RespondSuccess(ctx, &exchangeCodeResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		DeviceID:     exchangeCode.DeviceID,
+	})
+}
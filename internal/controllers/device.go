@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"oauth-go/internal/store"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/response"
+)
+
+var (
+	DeviceCodeTTL = 10 * time.Minute
+	// minimal polling interval of token endpoint, faster polling gets slow_down
+	DeviceCodeInterval = 5 * time.Second
+	// expired device codes are kept, so polling device gets expired_token instead of invalid_grant
+	DeviceCodeRetention = time.Hour
+)
+
+// user codes use consonants only, so they are easy to type and never form words (RFC 8628 section 6.1)
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+
+	for i := range code {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = userCodeAlphabet[index.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// normalizeUserCode drops dashes, spaces and other characters user may type
+func normalizeUserCode(input string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			return r
+		}
+
+		return -1
+	}, strings.ToUpper(input))
+}
+
+// formatUserCode splits user code in halves, e.g. WDJB-MJHT
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+type deviceAuthorizationRequest struct {
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code" example:"WDJB-MJHT"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in" example:"600"`
+	Interval                int    `json:"interval" example:"5"`
+}
+
+// @Summary		Device Authorization
+// @Description	Starts device authorization grant (RFC 8628) for devices without browser. Device shows user_code
+// @Description	and verification_uri to user, then polls token endpoint with device_code until user approves it
+// @Tags			  oauth
+// @Accept			x-www-form-urlencoded
+// @Produce		  json
+// @Param       client_id formData string false "Client id, when not sent with basic authentication"
+// @Param       client_secret formData string false "Client secret, when not sent with basic authentication"
+// @Param       scope formData string false "Space separated scopes, all client scopes when empty"
+// @Success     200 {object} deviceAuthorizationResponse
+// @Failure		  400	{object} response.OAuthError
+// @Failure		  401	{object} response.OAuthError
+// @Router			/oauth/device_authorization [post]
+func (controller *oauthController) DeviceAuthorization(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+
+	var req deviceAuthorizationRequest
+
+	if oauthErr := bindPostForm(ctx, &req); oauthErr != nil {
+		response.RespondOAuthError(ctx, oauthErr)
+		return
+	}
+
+	client, oauthErr := controller.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+
+	if oauthErr != nil {
+		respondClientError(ctx, oauthErr)
+		return
+	}
+
+	if !client.AllowsGrant(types.GrantDeviceCode) {
+		response.RespondOAuthError(ctx, response.OAuthUnauthorizedClient)
+		return
+	}
+
+	scopes, oauthErr := grantedScopes(client, req.Scope)
+
+	if oauthErr != nil {
+		response.RespondOAuthError(ctx, oauthErr)
+		return
+	}
+
+	deviceCode, err := generateRandomString(32)
+
+	if err != nil {
+		controller.app.Logger.Error("cannot generate device code", "error", err)
+		response.RespondOAuthError(ctx, response.OAuthServerError)
+		return
+	}
+
+	userCode, err := generateUserCode()
+
+	if err == nil {
+		err = controller.app.Store.DeviceCode.CreateDeviceCode(ctx.Request.Context(), deviceCode, &store.DeviceCode{
+			ClientID:  client.ClientID,
+			Scopes:    scopes,
+			UserCode:  userCode,
+			Status:    store.DeviceCodePending,
+			ExpiresAt: time.Now().Add(DeviceCodeTTL),
+		}, DeviceCodeTTL+DeviceCodeRetention)
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot create device code", "error", err)
+		response.RespondOAuthError(ctx, response.OAuthServerError)
+		return
+	}
+
+	controller.app.Logger.Info("device authorization started", "client_id", client.ClientID)
+
+	verificationURI := controller.app.Config.OAuthIssuer + "/device"
+
+	ctx.JSON(http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: withQuery(verificationURI, url.Values{"user_code": {formatUserCode(userCode)}}),
+		ExpiresIn:               int(DeviceCodeTTL.Seconds()),
+		Interval:                int(DeviceCodeInterval.Seconds()),
+	})
+}
+
+// exchangeDeviceCode answers device polling, tokens are issued once user approves the device
+func (controller *oauthController) exchangeDeviceCode(ctx *gin.Context, client *store.OAuthClient, req *tokenRequest) (*tokenResponse, *response.OAuthError) {
+	deviceCode, err := controller.app.Store.DeviceCode.GetDeviceCode(ctx.Request.Context(), req.DeviceCode)
+
+	if errors.Is(err, store.ErrDeviceCodeNotFound) {
+		return nil, response.OAuthInvalidGrant.WithDescription("device code is invalid")
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot get device code", "error", err)
+		return nil, response.OAuthServerError
+	}
+
+	if deviceCode.ClientID != client.ClientID {
+		return nil, response.OAuthInvalidGrant.WithDescription("device code was issued to another client")
+	}
+
+	if time.Now().After(deviceCode.ExpiresAt) {
+		return nil, response.OAuthExpiredToken
+	}
+
+	switch deviceCode.Status {
+	case store.DeviceCodePending:
+		allowed, err := controller.app.Store.DeviceCode.PollDeviceCode(ctx.Request.Context(), req.DeviceCode, DeviceCodeInterval)
+
+		if err != nil {
+			controller.app.Logger.Error("cannot save device poll", "error", err)
+			return nil, response.OAuthServerError
+		}
+
+		if !allowed {
+			return nil, response.OAuthSlowDown
+		}
+
+		return nil, response.OAuthAuthorizationPending
+	case store.DeviceCodeDenied:
+		controller.app.Store.DeviceCode.ConsumeDeviceCode(ctx.Request.Context(), req.DeviceCode)
+
+		return nil, response.OAuthAccessDenied.WithDescription("user denied the device")
+	}
+
+	// concurrent polls of approved device cannot both get tokens
+	deviceCode, err = controller.app.Store.DeviceCode.ConsumeDeviceCode(ctx.Request.Context(), req.DeviceCode)
+
+	if err != nil {
+		return nil, response.OAuthInvalidGrant.WithDescription("device code is already used")
+	}
+
+	return controller.grantUserTokens(ctx, client, &userGrant{
+		UserID:    deviceCode.UserID,
+		SessionID: deviceCode.SessionID,
+		Scopes:    deviceCode.Scopes,
+		AuthTime:  deviceCode.AuthTime,
+		AMR:       deviceCode.AMR,
+	})
+}
+
+var deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+{{if .Done}}
+<h1>{{if .Approved}}Device connected{{else}}Device denied{{end}}</h1>
+<p>You can close this page and return to your device.</p>
+{{else if .Client}}
+<h1>Allow {{.Client}} to access your account?</h1>
+<p>Make sure code {{.UserCode}} is shown on your device.</p>
+{{if .Scopes}}<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>{{end}}
+<form method="post">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{else}}
+<h1>Connect a device</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<form method="get">
+<input name="user_code" value="{{.UserCode}}" placeholder="XXXX-XXXX" autocomplete="off" autofocus>
+<button type="submit">Continue</button>
+</form>
+{{end}}
+</body>
+</html>`))
+
+type devicePage struct {
+	UserCode string
+	Error    string
+	// set on confirmation
+	Client    string
+	Scopes    []string
+	CSRFToken string
+	// set after user decision
+	Done     bool
+	Approved bool
+}
+
+func renderDevicePage(ctx *gin.Context, status int, page *devicePage) {
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Status(status)
+	deviceTemplate.Execute(ctx.Writer, page)
+}
+
+// deviceCSRFToken binds confirmation form to sso session of browser and user code,
+// the token is derived from HttpOnly sso cookie, so other sites cannot forge it
+func deviceCSRFToken(ctx *gin.Context, userCode string) string {
+	ssoSessionID, _ := ctx.Cookie(SSOSessionCookieName)
+
+	return hashBinding("device:" + ssoSessionID + ":" + normalizeUserCode(userCode))
+}
+
+// pendingDeviceCode returns pending device code of user code and its client
+func (controller *oauthController) pendingDeviceCode(ctx *gin.Context, userCode string) (string, *store.DeviceCode, *store.OAuthClient, error) {
+	deviceCode, data, err := controller.app.Store.DeviceCode.GetDeviceCodeByUserCode(ctx.Request.Context(), normalizeUserCode(userCode))
+
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if data.Status != store.DeviceCodePending || time.Now().After(data.ExpiresAt) {
+		return "", nil, nil, store.ErrDeviceCodeNotFound
+	}
+
+	client, err := controller.app.Store.OAuthClient.GetClientBy(ctx.Request.Context(), map[string]any{"client_id": data.ClientID})
+
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return deviceCode, data, client, nil
+}
+
+// @Summary		Device Verification
+// @Description	Page where signed-in user enters user_code shown by device and approves it, not working in swagger.
+// @Description	User signs in with upstream provider unless browser has sso session
+// @Tags			  oauth
+// @Produce		  html
+// @Param       user_code query string false "User code shown by device"
+// @Param       provider query string false "Upstream provider to sign in with, chooser is shown when empty"
+// @Success     200 "Verification page"
+// @Router			/oauth/device [get]
+func (controller *oauthController) DevicePage(ctx *gin.Context) {
+	userCode := ctx.Query("user_code")
+
+	if _, err := controller.currentSSOSession(ctx); err != nil {
+		controller.app.Logger.Debug("no sso session", "error", err)
+
+		deviceURL := controller.app.Config.OAuthIssuer + "/device?" + ctx.Request.URL.RawQuery
+
+		if oauthErr := controller.signInUpstream(ctx, deviceURL, ctx.Query("provider"), "connect a device"); oauthErr != nil {
+			renderDevicePage(ctx, oauthErr.Status, &devicePage{Error: "Cannot sign in, please try again later"})
+		}
+
+		return
+	}
+
+	if userCode == "" {
+		renderDevicePage(ctx, http.StatusOK, &devicePage{})
+		return
+	}
+
+	_, deviceCode, client, err := controller.pendingDeviceCode(ctx, userCode)
+
+	if err != nil {
+		controller.app.Logger.Debug("invalid user code", "error", err)
+		renderDevicePage(ctx, http.StatusOK, &devicePage{UserCode: userCode, Error: "Code is invalid or expired"})
+		return
+	}
+
+	renderDevicePage(ctx, http.StatusOK, &devicePage{
+		UserCode:  formatUserCode(deviceCode.UserCode),
+		Client:    client.Name,
+		Scopes:    deviceCode.Scopes,
+		CSRFToken: deviceCSRFToken(ctx, deviceCode.UserCode),
+	})
+}
+
+// @Summary		Device Approval
+// @Description	Approves or denies device of user_code, not working in swagger
+// @Tags			  oauth
+// @Accept			x-www-form-urlencoded
+// @Produce		  html
+// @Param       user_code formData string true "User code shown by device"
+// @Param       action formData string true "approve or deny"
+// @Param       csrf_token formData string true "Token of confirmation form"
+// @Success     200 "Result page"
+// @Router			/oauth/device [post]
+func (controller *oauthController) ApproveDevice(ctx *gin.Context) {
+	userCode := ctx.PostForm("user_code")
+
+	// sso cookie is SameSite=Lax, so cross-site form posts have no session and cannot approve devices
+	ssoSession, err := controller.currentSSOSession(ctx)
+
+	if err != nil {
+		controller.app.Logger.Debug("no sso session", "error", err)
+		ctx.Redirect(http.StatusSeeOther, withQuery(controller.app.Config.OAuthIssuer+"/device", url.Values{"user_code": {userCode}}))
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(ctx.PostForm("csrf_token")), []byte(deviceCSRFToken(ctx, userCode))) != 1 {
+		controller.app.Logger.Info("device decision with invalid csrf token", "user_id", ssoSession.UserID)
+		renderDevicePage(ctx, http.StatusForbidden, &devicePage{UserCode: userCode, Error: "Request has expired, please enter the code again"})
+		return
+	}
+
+	code, deviceCode, client, err := controller.pendingDeviceCode(ctx, userCode)
+
+	if err != nil {
+		controller.app.Logger.Debug("invalid user code", "error", err)
+		renderDevicePage(ctx, http.StatusOK, &devicePage{UserCode: userCode, Error: "Code is invalid or expired"})
+		return
+	}
+
+	approved := ctx.PostForm("action") == "approve"
+
+	if approved {
+		deviceCode.Status = store.DeviceCodeApproved
+		deviceCode.UserID = ssoSession.UserID
+		deviceCode.SessionID = ssoSession.SessionID
+		deviceCode.AuthTime = ssoSession.CreatedAt
+		deviceCode.Provider = ssoSession.Provider
+		deviceCode.AMR = ssoSession.AMR
+	} else {
+		deviceCode.Status = store.DeviceCodeDenied
+	}
+
+	err = controller.app.Store.DeviceCode.DecideDeviceCode(ctx.Request.Context(), code, deviceCode)
+
+	// device was approved or denied meanwhile, e.g. in another tab
+	if errors.Is(err, store.ErrDeviceCodeNotFound) {
+		renderDevicePage(ctx, http.StatusOK, &devicePage{UserCode: userCode, Error: "Code is invalid or expired"})
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot update device code", "error", err)
+		renderDevicePage(ctx, http.StatusInternalServerError, &devicePage{UserCode: userCode, Error: "Something went wrong, please try again"})
+		return
+	}
+
+	controller.app.Logger.Info("device authorization decided", "client_id", client.ClientID, "user_id", ssoSession.UserID, "approved", approved)
+
+	renderDevicePage(ctx, http.StatusOK, &devicePage{Done: true, Approved: approved})
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"oauth-go/internal/app"
+	"oauth-go/internal/store"
+	"oauth-go/internal/types"
+)
+
+func TestDeviceCodePolling(t *testing.T) {
+	tests := []struct {
+		name string
+		// decide changes device code as verification page or time does
+		decide func(t *testing.T, testApp *app.App, db *memoryDB, deviceCode string, session *store.UserSession)
+		// poll with another client or device code when set
+		clientID   string
+		deviceCode string
+		// wait lets interval pass between polls
+		wait bool
+		// expected error code of each poll, empty when tokens are issued
+		polls []string
+	}{
+		{
+			name:  "pending",
+			wait:  true,
+			polls: []string{"authorization_pending", "authorization_pending"},
+		},
+		{
+			name:  "polled faster than interval",
+			polls: []string{"authorization_pending", "slow_down"},
+		},
+		{
+			name:   "approved",
+			decide: decideDevice(store.DeviceCodeApproved),
+			polls:  []string{"", "invalid_grant"},
+		},
+		{
+			name:   "denied",
+			decide: decideDevice(store.DeviceCodeDenied),
+			polls:  []string{"access_denied", "invalid_grant"},
+		},
+		{
+			name: "expired",
+			decide: func(t *testing.T, testApp *app.App, db *memoryDB, deviceCode string, session *store.UserSession) {
+				db.mu.Lock()
+				defer db.mu.Unlock()
+
+				var data store.DeviceCode
+				db.get("device:"+deviceCode, &data)
+				data.ExpiresAt = time.Now().Add(-time.Second)
+				db.set("device:"+deviceCode, &data)
+			},
+			polls: []string{"expired_token"},
+		},
+		{
+			name: "approved after user signed out",
+			decide: func(t *testing.T, testApp *app.App, db *memoryDB, deviceCode string, session *store.UserSession) {
+				decideDevice(store.DeviceCodeApproved)(t, testApp, db, deviceCode, session)
+				testApp.Store.Session.DeleteSessionBy(context.Background(), map[string]any{"id": session.ID})
+			},
+			polls: []string{"invalid_grant"},
+		},
+		{
+			name:     "device code of another client",
+			decide:   decideDevice(store.DeviceCodeApproved),
+			clientID: "another",
+			polls:    []string{"invalid_grant"},
+		},
+		{
+			name:       "unknown device code",
+			deviceCode: "unknown",
+			polls:      []string{"invalid_grant"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testApp, db := newTestApp(t, "")
+			session := seedOAuth(t, testApp)
+
+			recorder := postForm(testApp, "/api/v1/oauth/device_authorization", url.Values{
+				"client_id": {"public"},
+				"scope":     {types.ScopeOpenID},
+			})
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("device authorization responded %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			var authorization deviceAuthorizationResponse
+			decodeBody(t, recorder, &authorization)
+
+			if test.decide != nil {
+				test.decide(t, testApp, db, authorization.DeviceCode, session)
+			}
+
+			form := url.Values{
+				"grant_type":  {types.GrantDeviceCode},
+				"device_code": {authorization.DeviceCode},
+				"client_id":   {"public"},
+			}
+
+			if test.deviceCode != "" {
+				form.Set("device_code", test.deviceCode)
+			}
+
+			if test.clientID != "" {
+				form.Set("client_id", test.clientID)
+				form.Set("client_secret", testClientSecret)
+			}
+
+			for _, errorCode := range test.polls {
+				if test.wait {
+					db.allowPoll(authorization.DeviceCode)
+				}
+
+				status := http.StatusOK
+
+				if errorCode != "" {
+					status = http.StatusBadRequest
+				}
+
+				tokens := checkTokenResponse(t, postForm(testApp, "/api/v1/oauth/token", form), status, errorCode)
+
+				if errorCode == "" && tokens.IDToken == "" {
+					t.Fatalf("id token is not issued: %+v", tokens)
+				}
+			}
+		})
+	}
+}
+
+// decideDevice decides device code as user does on verification page
+func decideDevice(status string) func(t *testing.T, testApp *app.App, db *memoryDB, deviceCode string, session *store.UserSession) {
+	return func(t *testing.T, testApp *app.App, db *memoryDB, deviceCode string, session *store.UserSession) {
+		t.Helper()
+
+		data, err := testApp.Store.DeviceCode.GetDeviceCode(context.Background(), deviceCode)
+
+		if err == nil {
+			data.Status = status
+			data.UserID = int(session.UserID)
+			data.SessionID = session.ID
+			data.AuthTime = time.Now()
+			err = testApp.Store.DeviceCode.DecideDeviceCode(context.Background(), deviceCode, data)
+		}
+
+		if err != nil {
+			t.Fatalf("cannot decide device code: %v", err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"oauth-go/internal/app"
+	"oauth-go/internal/middleware"
+	ouathservice "oauth-go/internal/services/oauth"
+	"oauth-go/internal/store"
+	"oauth-go/pkg/response"
+)
+
+type identityController struct {
+	app *app.App
+}
+
+func NewIdentityController(app *app.App) *identityController {
+	return &identityController{
+		app: app,
+	}
+}
+
+// linkIdentity links provider identity to user,
+// identity which already belongs to another user is never moved
+func linkIdentity(app *app.App, ctx context.Context, userID int, provider string, profile *ouathservice.ProfileImpl) (*store.UserIdentity, *response.APIError) {
+	filters := map[string]any{
+		"provider":         provider,
+		"provider_user_id": profile.ID,
+	}
+
+	identity, err := app.Store.Identity.GetIdentityBy(ctx, filters)
+
+	if err == nil {
+		if identity.UserID != userID {
+			app.Logger.Info("identity is linked to another user", "user_id", userID, "provider", provider)
+			return nil, response.ErrIdentityLinked
+		}
+
+		return identity, nil
+	}
+
+	if !errors.Is(err, store.ErrIdentityNotFound) {
+		app.Logger.Error("cannot get identity", "error", err)
+		return nil, response.ErrInternalServerError
+	}
+
+	identity, err = app.Store.Identity.CreateIdentity(ctx, &store.UserIdentityDto{
+		UserID:         userID,
+		Provider:       provider,
+		ProviderUserID: profile.ID,
+		Email:          profile.Email,
+		EmailVerified:  profile.EmailVerified,
+	})
+
+	if err != nil {
+		app.Logger.Error("cannot create identity", "error", err)
+		return nil, response.ErrInternalServerError
+	}
+
+	app.Logger.Info("identity linked", "user_id", userID, "provider", provider)
+
+	return identity, nil
+}
+
+type getIdentitiesResponse struct {
+	Identities []*store.UserIdentity `json:"identities"`
+}
+
+// @Summary		Identities
+// @Description	Returns provider identities linked to current user with granted scopes,
+// @Description	missing scopes can be requested with scope parameter of sign-in or link
+// @Tags			  identities
+// @Security BearerAuth
+// @Accept			json
+// @Produce		  json
+// @Success     200 {object} response.APISuccessResponse{data=getIdentitiesResponse}
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  500	{object} response.APIErrorResponse
+// @Router			/auth/identities [get]
+func (controller *identityController) GetIdentities(ctx *gin.Context) {
+	user, err := middleware.MustGetUserFromContext(ctx)
+
+	if err != nil {
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	identities, err := controller.app.Store.Identity.GetIdentitiesBy(ctx.Request.Context(), map[string]any{"user_id": user.ID})
+
+	if err != nil {
+		controller.app.Logger.Error("cannot get identities", "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	response.RespondSuccess(ctx, &getIdentitiesResponse{Identities: identities})
+}
+
+type linkIdentityResponse struct {
+	Identity *store.UserIdentity `json:"identity"`
+	ReturnTo string              `json:"return_to,omitempty"`
+}
+
+// @Summary		Link Identity
+// @Description	Returns provider login URL, provider callback links identity to current user
+// @Tags			  identities
+// @Security BearerAuth
+// @Accept			json
+// @Produce		  json
+// @Param       provider path string true "Provider to link, one of enabled OAUTH_PROVIDERS"
+// @Param       return_to query string false "Frontend url to return to after linking, must be allowlisted in ALLOWED_RETURN_URLS"
+// @Param       scope query string false "Extra space separated scopes, must be allowlisted in <PROVIDER>_ALLOWED_SCOPES"
+// @Param       redirect query string false "Name of registered provider redirect url from <PROVIDER>_REDIRECT_URLS, <PROVIDER>_REDIRECT_URL when empty"
+// @Success     200 {object} response.APISuccessResponse{data=signInResponse}
+// @Failure		  400	{object} response.APIErrorResponse
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  503	{object} response.APIErrorResponse
+// @Router			/auth/identities/link/{provider} [get]
+func (controller *identityController) LinkIdentity(ctx *gin.Context) {
+	user, err := middleware.MustGetUserFromContext(ctx)
+
+	if err != nil {
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	request, apiErr := parseSignInRequest(controller.app, ctx)
+
+	if apiErr != nil {
+		response.RespondError(ctx, apiErr)
+		return
+	}
+
+	request.LinkUserID = user.ID
+
+	url, apiErr := startSignIn(controller.app, ctx, ctx.Param("provider"), request)
+
+	if apiErr != nil {
+		response.RespondError(ctx, apiErr)
+		return
+	}
+
+	response.RespondSuccess(ctx, &signInResponse{
+		URL: url,
+	})
+}
+
+type unlinkIdentityResponse struct{}
+
+// @Summary		Unlink Identity
+// @Description	Removes identity from current user, the last identity cannot be removed
+// @Tags			  identities
+// @Security BearerAuth
+// @Accept			json
+// @Produce		  json
+// @Param       id path int true "Identity id"
+// @Success     200 {object} response.APISuccessResponse
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  404	{object} response.APIErrorResponse
+// @Failure		  409	{object} response.APIErrorResponse
+// @Router			/auth/identities/{id} [delete]
+func (controller *identityController) UnlinkIdentity(ctx *gin.Context) {
+	user, err := middleware.MustGetUserFromContext(ctx)
+
+	if err != nil {
+		response.RespondError(ctx, response.ErrUnauthorized)
+		return
+	}
+
+	identityID, err := strconv.Atoi(ctx.Param("id"))
+
+	if err != nil {
+		response.RespondError(ctx, response.ErrInvalidInput)
+		return
+	}
+
+	err = controller.app.Store.Identity.DeleteIdentity(ctx.Request.Context(), user.ID, identityID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrLastIdentity):
+			response.RespondError(ctx, response.ErrLastIdentity)
+		case errors.Is(err, store.ErrIdentityNotFound):
+			response.RespondError(ctx, response.ErrorNotFound)
+		default:
+			controller.app.Logger.Error("cannot delete identity", "error", err)
+			response.RespondError(ctx, response.ErrInternalServerError)
+		}
+		return
+	}
+
+	controller.app.Logger.Info("identity unlinked", "user_id", user.ID, "identity_id", identityID)
+
+	// roles granted only through unlinked identity are taken away
+	if controller.app.Services.Claims.Enabled() {
+		if _, err := mergeUserClaims(controller.app, ctx.Request.Context(), user); err != nil {
+			controller.app.Logger.Error("cannot merge user claims", "user_id", user.ID, "error", err)
+		}
+	}
+
+	response.RespondSuccess(ctx, &unlinkIdentityResponse{})
+}
@@ -0,0 +1,390 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"oauth-go/internal/app"
+	ouathservice "oauth-go/internal/services/oauth"
+	"oauth-go/internal/store"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/response"
+)
+
+type internalController struct {
+	app *app.App
+}
+
+func NewInternalController(app *app.App) *internalController {
+	return &internalController{
+		app: app,
+	}
+}
+
+// saveProviderToken stores upstream tokens and granted scopes of the identity used to sign in
+func saveProviderToken(app *app.App, ctx context.Context, provider string, profile *ouathservice.ProfileImpl, token *oauth2.Token, request *ouathservice.AuthRequest) error {
+	filters := map[string]any{
+		"provider":         provider,
+		"provider_user_id": profile.ID,
+	}
+
+	identity, err := app.Store.Identity.GetIdentityBy(ctx, filters)
+
+	if err != nil {
+		return err
+	}
+
+	scopes := app.Services.OAuth.GrantedScopes(provider, token, request)
+
+	err = app.Store.Identity.UpdateIdentityScopes(ctx, identity.ID, scopes)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = app.Store.Token.SaveToken(ctx, &store.ProviderTokenDto{
+		IdentityID:   identity.ID,
+		UserID:       identity.UserID,
+		Provider:     provider,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.Type(),
+		ExpiresAt:    token.Expiry,
+	})
+
+	return err
+}
+
+// getProviderToken returns stored provider token of identity,
+// expired token is refreshed and saved back
+func getProviderToken(app *app.App, ctx context.Context, identity *store.UserIdentity) (*store.ProviderToken, error) {
+	provider := identity.Provider
+
+	stored, err := app.Store.Token.GetTokenBy(ctx, map[string]any{"identity_id": identity.ID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := &oauth2.Token{
+		AccessToken: stored.AccessToken,
+		TokenType:   stored.TokenType,
+	}
+
+	if stored.RefreshToken != nil {
+		token.RefreshToken = *stored.RefreshToken
+	}
+
+	if stored.ExpiresAt != nil {
+		token.Expiry = *stored.ExpiresAt
+	}
+
+	refreshed, err := app.Services.OAuth.RefreshToken(ctx, provider, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshed == token {
+		return stored, nil
+	}
+
+	return app.Store.Token.SaveToken(ctx, &store.ProviderTokenDto{
+		IdentityID:   stored.IdentityID,
+		UserID:       stored.UserID,
+		Provider:     provider,
+		AccessToken:  refreshed.AccessToken,
+		RefreshToken: refreshed.RefreshToken,
+		TokenType:    refreshed.Type(),
+		ExpiresAt:    refreshed.Expiry,
+	})
+}
+
+type getProviderTokenResponse struct {
+	AccessToken string     `json:"access_token"`
+	TokenType   string     `json:"token_type"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Scopes      []string   `json:"scopes"`
+}
+
+// @Summary		Provider Token
+// @Description	Returns valid upstream provider access token of user, for internal services only.
+// @Description	identity_id is required when user has several identities at the provider
+// @Tags			  internal
+// @Accept			json
+// @Produce		  json
+// @Param       X-Internal-Api-Key header string true "Internal service api key"
+// @Param       id path int true "User id"
+// @Param       provider path string true "Provider name"
+// @Param       identity_id query int false "Identity id, see GET /auth/identities"
+// @Success     200 {object} response.APISuccessResponse{data=getProviderTokenResponse}
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  404	{object} response.APIErrorResponse
+// @Failure		  409	{object} response.APIErrorResponse
+// @Failure		  500	{object} response.APIErrorResponse
+// @Failure		  503	{object} response.APIErrorResponse
+// @Router			/internal/users/{id}/tokens/{provider} [get]
+func (controller *internalController) GetProviderToken(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("id"))
+
+	if err != nil {
+		response.RespondError(ctx, response.ErrInvalidInput)
+		return
+	}
+
+	provider := ctx.Param("provider")
+
+	identities, err := controller.app.Store.Identity.GetIdentitiesBy(ctx.Request.Context(), map[string]any{
+		"user_id":  userID,
+		"provider": provider,
+	})
+
+	if err != nil {
+		controller.app.Logger.Error("cannot get identities", "user_id", userID, "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	if identityID := ctx.Query("identity_id"); identityID != "" {
+		identities = slices.DeleteFunc(identities, func(identity *store.UserIdentity) bool {
+			return strconv.Itoa(identity.ID) != identityID
+		})
+	}
+
+	if len(identities) == 0 {
+		response.RespondError(ctx, response.ErrorNotFound)
+		return
+	}
+
+	// token of another account at the same provider must not be returned by chance
+	if len(identities) > 1 {
+		response.RespondError(ctx, response.ErrAmbiguousIdentity)
+		return
+	}
+
+	identity := identities[0]
+
+	token, err := getProviderToken(controller.app, ctx.Request.Context(), identity)
+
+	if errors.Is(err, store.ErrTokenNotFound) {
+		response.RespondError(ctx, response.ErrorNotFound)
+		return
+	}
+
+	if errors.Is(err, ouathservice.ErrProviderUnavailable) {
+		controller.app.Logger.Warn("provider is unavailable", "provider", provider, "error", err)
+		response.RespondError(ctx, response.ErrProviderUnavailable)
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot get provider token", "user_id", userID, "provider", provider, "error", err)
+		response.RespondError(ctx, response.ErrOAuth)
+		return
+	}
+
+	response.RespondSuccess(ctx, &getProviderTokenResponse{
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		ExpiresAt:   token.ExpiresAt,
+		Scopes:      strings.Fields(identity.Scopes),
+	})
+}
+
+// @Summary		Provider Stats
+// @Description	Returns request counters and circuit breaker state of each enabled provider, for internal services only
+// @Tags			  internal
+// @Accept			json
+// @Produce		  json
+// @Param       X-Internal-Api-Key header string true "Internal service api key"
+// @Success     200 {object} response.APISuccessResponse{data=[]ouathservice.UpstreamStats}
+// @Failure		  401	{object} response.APIErrorResponse
+// @Router			/internal/providers/stats [get]
+func (controller *internalController) GetProviderStats(ctx *gin.Context) {
+	response.RespondSuccess(ctx, controller.app.Services.OAuth.UpstreamStats())
+}
+
+type createOAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required" example:"Admin console"`
+	ClientType   string   `json:"client_type" binding:"required" example:"confidential"`
+	RedirectURIs []string `json:"redirect_uris" example:"https://admin.example.com/oauth/callback"`
+	GrantTypes   []string `json:"grant_types" binding:"required" example:"authorization_code,refresh_token"`
+	Scopes       []string `json:"scopes" example:"orders:read"`
+	// first-party app, users are not asked for consent
+	Trusted bool `json:"trusted" example:"true"`
+}
+
+type createOAuthClientResponse struct {
+	Client *store.OAuthClient `json:"client"`
+	// returned only once for confidential clients, only its hash is stored
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// validateOAuthClient checks client type, grants and redirect uris of new client
+func validateOAuthClient(req *createOAuthClientRequest) error {
+	if !slices.Contains(types.OAuthClientTypes, req.ClientType) {
+		return fmt.Errorf("unknown client type %s", req.ClientType)
+	}
+
+	for _, grant := range req.GrantTypes {
+		if !slices.Contains(types.GrantTypes, grant) {
+			return fmt.Errorf("unknown grant type %s", grant)
+		}
+	}
+
+	if slices.Contains(req.GrantTypes, types.GrantClientCredentials) && req.ClientType != types.OAuthClientConfidential {
+		return fmt.Errorf("client_credentials grant requires confidential client")
+	}
+
+	if slices.Contains(req.GrantTypes, types.GrantAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		return fmt.Errorf("authorization_code grant requires redirect uris")
+	}
+
+	for _, uri := range req.RedirectURIs {
+		redirectURL, err := url.Parse(uri)
+
+		if err != nil || !redirectURL.IsAbs() || redirectURL.Fragment != "" {
+			return fmt.Errorf("redirect uri %s must be absolute uri without fragment", uri)
+		}
+	}
+
+	return nil
+}
+
+// @Summary		Create OAuth Client
+// @Description	Registers application which signs users in with this service, client secret is returned only once
+// @Tags			  internal
+// @Accept			json
+// @Produce		  json
+// @Param       X-Internal-Api-Key header string true "Internal service api key"
+// @Param       request body createOAuthClientRequest true "Client"
+// @Success     201 {object} response.APISuccessResponse{data=createOAuthClientResponse}
+// @Failure		  400	{object} response.APIErrorResponse
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  500	{object} response.APIErrorResponse
+// @Router			/internal/oauth/clients [post]
+func (controller *internalController) CreateOAuthClient(ctx *gin.Context) {
+	var req createOAuthClientRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response.RespondError(ctx, response.ErrInvalidInput)
+		return
+	}
+
+	if err := validateOAuthClient(&req); err != nil {
+		controller.app.Logger.Info("invalid oauth client", "error", err)
+		response.RespondError(ctx, response.NewError(http.StatusBadRequest, response.ErrInvalidInput.Message, err.Error()))
+		return
+	}
+
+	clientID, err := generateRandomString(16)
+
+	if err != nil {
+		controller.app.Logger.Error("cannot generate client id", "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	var clientSecret string
+
+	if req.ClientType == types.OAuthClientConfidential {
+		clientSecret, err = generateRandomString(32)
+
+		if err != nil {
+			controller.app.Logger.Error("cannot generate client secret", "error", err)
+			response.RespondError(ctx, response.ErrInternalServerError)
+			return
+		}
+	}
+
+	client, err := controller.app.Store.OAuthClient.CreateClient(ctx.Request.Context(), &store.OAuthClientDto{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Name:         req.Name,
+		ClientType:   req.ClientType,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+		Trusted:      req.Trusted,
+	})
+
+	if err != nil {
+		controller.app.Logger.Error("cannot create oauth client", "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	controller.app.Logger.Info("oauth client created", "client_id", client.ClientID, "name", client.Name)
+
+	response.RespondCreated(ctx, &createOAuthClientResponse{
+		Client:       client,
+		ClientSecret: clientSecret,
+	})
+}
+
+type getOAuthClientsResponse struct {
+	Clients []*store.OAuthClient `json:"clients"`
+}
+
+// @Summary		OAuth Clients
+// @Description	Returns registered oauth clients
+// @Tags			  internal
+// @Accept			json
+// @Produce		  json
+// @Param       X-Internal-Api-Key header string true "Internal service api key"
+// @Success     200 {object} response.APISuccessResponse{data=getOAuthClientsResponse}
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  500	{object} response.APIErrorResponse
+// @Router			/internal/oauth/clients [get]
+func (controller *internalController) GetOAuthClients(ctx *gin.Context) {
+	clients, err := controller.app.Store.OAuthClient.GetClientsBy(ctx.Request.Context(), map[string]any{})
+
+	if err != nil {
+		controller.app.Logger.Error("cannot get oauth clients", "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	response.RespondSuccess(ctx, &getOAuthClientsResponse{Clients: clients})
+}
+
+// @Summary		Delete OAuth Client
+// @Description	Deletes oauth client, its authorization codes cannot be exchanged anymore
+// @Tags			  internal
+// @Accept			json
+// @Produce		  json
+// @Param       X-Internal-Api-Key header string true "Internal service api key"
+// @Param       client_id path string true "Client id"
+// @Success     204
+// @Failure		  401	{object} response.APIErrorResponse
+// @Failure		  404	{object} response.APIErrorResponse
+// @Failure		  500	{object} response.APIErrorResponse
+// @Router			/internal/oauth/clients/{client_id} [delete]
+func (controller *internalController) DeleteOAuthClient(ctx *gin.Context) {
+	err := controller.app.Store.OAuthClient.DeleteClientBy(ctx.Request.Context(), map[string]any{"client_id": ctx.Param("client_id")})
+
+	if errors.Is(err, store.ErrClientNotFound) {
+		response.RespondError(ctx, response.ErrorNotFound)
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot delete oauth client", "error", err)
+		response.RespondError(ctx, response.ErrInternalServerError)
+		return
+	}
+
+	controller.app.Logger.Info("oauth client deleted", "client_id", ctx.Param("client_id"))
+
+	response.RespondNoContent(ctx)
+}
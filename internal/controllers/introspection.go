@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	issuerservice "oauth-go/internal/services/issuer"
+	"oauth-go/internal/store"
+	"oauth-go/pkg/response"
+)
+
+// token_type_hint values of introspection and revocation requests
+const (
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+)
+
+var errTokenInactive = errors.New("token is not active")
+
+// activeToken verifies token issued to oauth client and checks it is not revoked
+// and its client still exists, tokens of user grants are active only while their
+// session is. Token type is detected by typ header, hint only decides which type is tried first
+func (controller *oauthController) activeToken(ctx *gin.Context, raw string, hint string) (*issuerservice.TokenClaims, string, error) {
+	verifiers := []struct {
+		tokenType string
+		verify    func(raw string) (*issuerservice.TokenClaims, error)
+	}{
+		{tokenTypeAccess, controller.app.Services.Issuer.VerifyAccessToken},
+		{tokenTypeRefresh, controller.app.Services.Issuer.VerifyRefreshToken},
+	}
+
+	if hint == tokenTypeRefresh {
+		verifiers[0], verifiers[1] = verifiers[1], verifiers[0]
+	}
+
+	var (
+		claims    *issuerservice.TokenClaims
+		tokenType string
+		err       error
+	)
+
+	for _, verifier := range verifiers {
+		claims, err = verifier.verify(raw)
+
+		if err == nil {
+			tokenType = verifier.tokenType
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", errTokenInactive, err)
+	}
+
+	revoked, err := controller.app.Store.RevokedToken.IsRevoked(ctx.Request.Context(), claims.ID)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if revoked {
+		return nil, "", fmt.Errorf("%w: token is revoked", errTokenInactive)
+	}
+
+	// tokens of deleted client are not active even before they expire
+	_, err = controller.app.Store.OAuthClient.GetClientBy(ctx.Request.Context(), map[string]any{"client_id": claims.ClientID})
+
+	if errors.Is(err, store.ErrClientNotFound) {
+		return nil, "", fmt.Errorf("%w: %w", errTokenInactive, err)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if claims.SessionID != 0 {
+		_, err = controller.app.Store.Session.GetSessionBy(ctx.Request.Context(), map[string]any{"id": claims.SessionID})
+
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", errTokenInactive, err)
+		}
+	}
+
+	return claims, tokenType, nil
+}
+
+type tokenActionRequest struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+type introspectionResponse struct {
+	Active bool   `json:"active"`
+	Scope  string `json:"scope,omitempty"`
+	// client token was issued to, not the one asking
+	ClientID string `json:"client_id,omitempty"`
+	// user id, client id for client_credentials tokens
+	Subject string `json:"sub,omitempty"`
+	// Bearer for access tokens, refresh_token for refresh tokens
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	JTI       string   `json:"jti,omitempty"`
+	// user session of the grant, revoking it deactivates all grant tokens
+	SessionID int      `json:"session_id,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// @Summary		Introspect
+// @Description	Token introspection (RFC 7662) for resource servers which don't verify tokens locally.
+// @Description	Only confidential clients may introspect, invalid, expired and revoked tokens are {"active":false}
+// @Tags			  oauth
+// @Accept			x-www-form-urlencoded
+// @Produce		  json
+// @Param       token formData string true "Access or refresh token"
+// @Param       token_type_hint formData string false "access_token or refresh_token"
+// @Param       client_id formData string false "Client id, when not sent with basic authentication"
+// @Param       client_secret formData string false "Client secret, when not sent with basic authentication"
+// @Success     200 {object} introspectionResponse
+// @Failure		  400	{object} response.OAuthError
+// @Failure		  401	{object} response.OAuthError
+// @Router			/oauth/introspect [post]
+func (controller *oauthController) Introspect(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+
+	var req tokenActionRequest
+
+	if oauthErr := bindPostForm(ctx, &req); oauthErr != nil {
+		response.RespondOAuthError(ctx, oauthErr)
+		return
+	}
+
+	if req.Token == "" {
+		response.RespondOAuthError(ctx, response.OAuthInvalidRequest.WithDescription("token is required"))
+		return
+	}
+
+	client, oauthErr := controller.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+
+	if oauthErr == nil && client.IsPublic() {
+		oauthErr = response.OAuthInvalidClient.WithDescription("introspection requires confidential client")
+	}
+
+	if oauthErr != nil {
+		respondClientError(ctx, oauthErr)
+		return
+	}
+
+	claims, tokenType, err := controller.activeToken(ctx, req.Token, req.TokenTypeHint)
+
+	if errors.Is(err, errTokenInactive) {
+		controller.app.Logger.Debug("inactive token introspected", "client_id", client.ClientID, "error", err)
+		ctx.JSON(http.StatusOK, introspectionResponse{Active: false})
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot introspect token", "error", err)
+		response.RespondOAuthError(ctx, response.OAuthServerError)
+		return
+	}
+
+	result := introspectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Subject:   claims.Subject,
+		TokenType: tokenTypeRefresh,
+		ExpiresAt: claims.ExpiresAt.Unix(),
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		JTI:       claims.ID,
+		SessionID: claims.SessionID,
+		Roles:     claims.Roles,
+	}
+
+	if tokenType == tokenTypeAccess {
+		result.TokenType = "Bearer"
+	}
+
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Unix()
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// @Summary		Revoke
+// @Description	Token revocation (RFC 7009). Revoking refresh token ends its grant session, so all tokens of the grant
+// @Description	become inactive. Revoked access tokens are denylisted until they expire. Unknown tokens are ignored
+// @Description	First-party tokens of /auth sign-in are not revocable here, they are valid until they expire or their session ends
+// @Tags			  oauth
+// @Accept			x-www-form-urlencoded
+// @Produce		  json
+// @Param       token formData string true "Access or refresh token"
+// @Param       token_type_hint formData string false "access_token or refresh_token"
+// @Param       client_id formData string false "Client id, when not sent with basic authentication"
+// @Param       client_secret formData string false "Client secret, when not sent with basic authentication"
+// @Success     200
+// @Failure		  400	{object} response.OAuthError
+// @Failure		  401	{object} response.OAuthError
+// @Router			/oauth/revoke [post]
+func (controller *oauthController) Revoke(ctx *gin.Context) {
+	var req tokenActionRequest
+
+	if oauthErr := bindPostForm(ctx, &req); oauthErr != nil {
+		response.RespondOAuthError(ctx, oauthErr)
+		return
+	}
+
+	if req.Token == "" {
+		response.RespondOAuthError(ctx, response.OAuthInvalidRequest.WithDescription("token is required"))
+		return
+	}
+
+	client, oauthErr := controller.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+
+	if oauthErr != nil {
+		respondClientError(ctx, oauthErr)
+		return
+	}
+
+	claims, tokenType, err := controller.activeToken(ctx, req.Token, req.TokenTypeHint)
+
+	// invalid and already revoked tokens need no action (RFC 7009 section 2.2)
+	if errors.Is(err, errTokenInactive) {
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot check revoked token", "error", err)
+		response.RespondOAuthError(ctx, response.OAuthServerError)
+		return
+	}
+
+	if claims.ClientID != client.ClientID {
+		response.RespondOAuthError(ctx, response.OAuthUnauthorizedClient.WithDescription("token was issued to another client"))
+		return
+	}
+
+	if tokenType == tokenTypeRefresh && claims.SessionID != 0 {
+		err = controller.app.Store.Session.DeleteSessionBy(ctx.Request.Context(), map[string]any{"id": claims.SessionID})
+	} else {
+		err = controller.app.Store.RevokedToken.RevokeToken(ctx.Request.Context(), claims.ID, time.Until(claims.ExpiresAt.Time))
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot revoke token", "error", err)
+		response.RespondOAuthError(ctx, response.OAuthServerError)
+		return
+	}
+
+	controller.app.Logger.Info("oauth token revoked", "client_id", client.ClientID, "token_type", tokenType, "sub", claims.Subject, "session_id", claims.SessionID)
+
+	ctx.Status(http.StatusOK)
+}
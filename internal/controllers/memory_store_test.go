@@ -0,0 +1,419 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"oauth-go/internal/store"
+)
+
+// memoryDB keeps rows of postgres stores and values of redis stores in memory.
+// Fakes embed store interfaces, so methods which tests don't reach are not implemented
+type memoryDB struct {
+	mu         sync.Mutex
+	nextID     int
+	users      []*store.User
+	identities []*store.UserIdentity
+	sessions   []*store.UserSession
+	clients    []*store.OAuthClient
+	// redis values are json encoded like in redis
+	keys map[string][]byte
+}
+
+func newMemoryStore() (*store.Store, *memoryDB) {
+	db := &memoryDB{keys: map[string][]byte{}}
+
+	return &store.Store{
+		User:       &memoryUsers{db: db},
+		Identity:   &memoryIdentities{db: db},
+		Token:      &memoryTokens{},
+		Session:    &memorySessions{db: db},
+		OAuthState: &memoryOAuthStates{db: db},
+		SSOSession: &memorySSOSessions{db: db},
+
+		OAuthClient:       &memoryClients{db: db},
+		AuthorizationCode: &memoryAuthorizationCodes{db: db},
+		DeviceCode:        &memoryDeviceCodes{db: db},
+	}, db
+}
+
+func (db *memoryDB) id() int {
+	db.nextID++
+
+	return db.nextID
+}
+
+// matches compares filters with fields of row by db tag, like goqu filters of stores
+func matches(row any, filters map[string]any) bool {
+	value := reflect.ValueOf(row).Elem()
+
+	for key, expected := range filters {
+		found := false
+
+		for i := range value.NumField() {
+			if value.Type().Field(i).Tag.Get("db") != key {
+				continue
+			}
+
+			field := reflect.Indirect(value.Field(i))
+
+			if !field.IsValid() || fmt.Sprint(field.Interface()) != fmt.Sprint(expected) {
+				return false
+			}
+
+			found = true
+		}
+
+		if !found {
+			panic("unknown filter " + key)
+		}
+	}
+
+	return true
+}
+
+func find[T any](rows []*T, filters map[string]any) (*T, bool) {
+	for _, row := range rows {
+		if matches(row, filters) {
+			copied := *row
+			return &copied, true
+		}
+	}
+
+	return nil, false
+}
+
+func (db *memoryDB) set(key string, data any) error {
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	db.keys[key] = value
+
+	return nil
+}
+
+func (db *memoryDB) get(key string, out any) bool {
+	value, ok := db.keys[key]
+
+	return ok && json.Unmarshal(value, out) == nil
+}
+
+// setNX saves key only when it does not exist, expiration is not tracked
+func (db *memoryDB) setNX(key string, data any) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.keys[key]; ok {
+		return fmt.Errorf("key %s already exists", key)
+	}
+
+	return db.set(key, data)
+}
+
+// getDel returns and deletes key at once
+func (db *memoryDB) getDel(key string, out any) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	found := db.get(key, out)
+	delete(db.keys, key)
+
+	return found
+}
+
+type memoryUsers struct {
+	store.UserStore
+	db *memoryDB
+}
+
+func (s *memoryUsers) CreateUser(ctx context.Context, dto *store.UserDto) (*store.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user := &store.User{
+		ID:              s.db.id(),
+		Name:            &dto.Name,
+		Email:           dto.Email,
+		AvatarURL:       &dto.AvatarURL,
+		IsEmailVerified: dto.IsEmailVerified,
+		CreatedAt:       time.Now(),
+	}
+
+	s.db.users = append(s.db.users, user)
+	s.db.identities = append(s.db.identities, &store.UserIdentity{
+		ID:             s.db.id(),
+		UserID:         user.ID,
+		Provider:       dto.Provider,
+		ProviderUserID: dto.ProviderUserID,
+		Email:          &dto.Email,
+		EmailVerified:  dto.IsEmailVerified,
+		CreatedAt:      time.Now(),
+	})
+
+	copied := *user
+
+	return &copied, nil
+}
+
+func (s *memoryUsers) GetUserBy(ctx context.Context, filters map[string]any) (*store.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user, ok := find(s.db.users, filters)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: user %s", store.ErrNotFound, filters)
+	}
+
+	return user, nil
+}
+
+type memoryIdentities struct {
+	store.IdentityStore
+	db *memoryDB
+}
+
+func (s *memoryIdentities) GetIdentityBy(ctx context.Context, filters map[string]any) (*store.UserIdentity, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	identity, ok := find(s.db.identities, filters)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", store.ErrIdentityNotFound, filters)
+	}
+
+	return identity, nil
+}
+
+func (s *memoryIdentities) UpdateIdentityScopes(ctx context.Context, identityID int, scopes []string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, identity := range s.db.identities {
+		if identity.ID == identityID {
+			identity.Scopes = strings.Join(scopes, " ")
+		}
+	}
+
+	return nil
+}
+
+// memoryTokens drops provider tokens, tests don't call providers apis
+type memoryTokens struct {
+	store.TokenStore
+}
+
+func (s *memoryTokens) SaveToken(ctx context.Context, dto *store.ProviderTokenDto) (*store.ProviderToken, error) {
+	return &store.ProviderToken{IdentityID: dto.IdentityID, UserID: dto.UserID, Provider: dto.Provider}, nil
+}
+
+type memorySessions struct {
+	store.SessionStore
+	db *memoryDB
+}
+
+func (s *memorySessions) CreateSession(ctx context.Context, dto *store.UserSessionDto) (*store.UserSession, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	session := &store.UserSession{
+		ID:           s.db.id(),
+		UserID:       int64(dto.UserID),
+		IPAddress:    dto.IPAddress,
+		UserAgent:    dto.UserAgent,
+		Location:     &dto.Location,
+		DeviceID:     dto.DeviceID,
+		LastActiveAt: time.Now(),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+
+	s.db.sessions = append(s.db.sessions, session)
+	copied := *session
+
+	return &copied, nil
+}
+
+func (s *memorySessions) GetSessionBy(ctx context.Context, filters map[string]any) (*store.UserSession, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	session, ok := find(s.db.sessions, filters)
+
+	if !ok {
+		return nil, fmt.Errorf("session not found: %s", filters)
+	}
+
+	return session, nil
+}
+
+func (s *memorySessions) DeleteSessionBy(ctx context.Context, filters map[string]any) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.sessions = slices.DeleteFunc(s.db.sessions, func(session *store.UserSession) bool { return matches(session, filters) })
+
+	return nil
+}
+
+type memoryOAuthStates struct {
+	store.OAuthStateStore
+	db *memoryDB
+}
+
+func (s *memoryOAuthStates) CreateState(ctx context.Context, state string, data *store.OAuthState, ttl time.Duration) error {
+	return s.db.setNX("state:"+state, data)
+}
+
+func (s *memoryOAuthStates) ConsumeState(ctx context.Context, state string) (*store.OAuthState, error) {
+	var data store.OAuthState
+
+	if !s.db.getDel("state:"+state, &data) {
+		return nil, errors.New("oauth state not found")
+	}
+
+	return &data, nil
+}
+
+type memorySSOSessions struct {
+	store.SSOSessionStore
+	db *memoryDB
+}
+
+func (s *memorySSOSessions) CreateSession(ctx context.Context, id string, data *store.SSOSession, ttl time.Duration) error {
+	return s.db.setNX("sso:"+id, data)
+}
+
+type memoryClients struct {
+	store.OAuthClientStore
+	db *memoryDB
+}
+
+func (s *memoryClients) CreateClient(ctx context.Context, dto *store.OAuthClientDto) (*store.OAuthClient, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	client := &store.OAuthClient{
+		ID:           s.db.id(),
+		ClientID:     dto.ClientID,
+		Name:         dto.Name,
+		ClientType:   dto.ClientType,
+		RedirectURIs: dto.RedirectURIs,
+		GrantTypes:   dto.GrantTypes,
+		Scopes:       dto.Scopes,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		Trusted:      dto.Trusted,
+	}
+
+	if dto.ClientSecret != "" {
+		hash := store.HashClientSecret(dto.ClientSecret)
+		client.ClientSecretHash = &hash
+	}
+
+	s.db.clients = append(s.db.clients, client)
+	copied := *client
+
+	return &copied, nil
+}
+
+func (s *memoryClients) GetClientBy(ctx context.Context, filters map[string]any) (*store.OAuthClient, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	client, ok := find(s.db.clients, filters)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", store.ErrClientNotFound, filters)
+	}
+
+	return client, nil
+}
+
+type memoryAuthorizationCodes struct {
+	store.AuthorizationCodeStore
+	db *memoryDB
+}
+
+func (s *memoryAuthorizationCodes) CreateCode(ctx context.Context, code string, data *store.AuthorizationCode, ttl time.Duration) error {
+	return s.db.setNX("code:"+code, data)
+}
+
+func (s *memoryAuthorizationCodes) ConsumeCode(ctx context.Context, code string) (*store.AuthorizationCode, error) {
+	var data store.AuthorizationCode
+
+	if !s.db.getDel("code:"+code, &data) {
+		return nil, errors.New("authorization code not found")
+	}
+
+	return &data, nil
+}
+
+type memoryDeviceCodes struct {
+	store.DeviceCodeStore
+	db *memoryDB
+}
+
+func (s *memoryDeviceCodes) CreateDeviceCode(ctx context.Context, deviceCode string, data *store.DeviceCode, ttl time.Duration) error {
+	return s.db.setNX("device:"+deviceCode, data)
+}
+
+func (s *memoryDeviceCodes) GetDeviceCode(ctx context.Context, deviceCode string) (*store.DeviceCode, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var data store.DeviceCode
+
+	if !s.db.get("device:"+deviceCode, &data) {
+		return nil, store.ErrDeviceCodeNotFound
+	}
+
+	return &data, nil
+}
+
+// DecideDeviceCode decides pending device code only, like the redis script
+func (s *memoryDeviceCodes) DecideDeviceCode(ctx context.Context, deviceCode string, data *store.DeviceCode) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var current store.DeviceCode
+
+	if !s.db.get("device:"+deviceCode, &current) || current.Status != store.DeviceCodePending {
+		return store.ErrDeviceCodeNotFound
+	}
+
+	return s.db.set("device:"+deviceCode, data)
+}
+
+func (s *memoryDeviceCodes) ConsumeDeviceCode(ctx context.Context, deviceCode string) (*store.DeviceCode, error) {
+	var data store.DeviceCode
+
+	if !s.db.getDel("device:"+deviceCode, &data) {
+		return nil, store.ErrDeviceCodeNotFound
+	}
+
+	return &data, nil
+}
+
+// PollDeviceCode allows one poll per device code until test lets interval pass with allowPoll
+func (s *memoryDeviceCodes) PollDeviceCode(ctx context.Context, deviceCode string, interval time.Duration) (bool, error) {
+	return s.db.setNX("device:poll:"+deviceCode, 1) == nil, nil
+}
+
+func (db *memoryDB) allowPoll(deviceCode string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.keys, "device:poll:"+deviceCode)
+}
@@ -0,0 +1,1013 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+
+	"oauth-go/internal/app"
+	issuerservice "oauth-go/internal/services/issuer"
+	"oauth-go/internal/store"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/response"
+)
+
+var (
+	SSOSessionCookieName = "sso_session"
+	// sso cookie is sent only to authorization server endpoints
+	SSOSessionCookiePath = app.OAuthPath
+	SSOSessionTTL        = 7 * 24 * time.Hour
+	AuthorizationCodeTTL = time.Minute
+)
+
+type oauthController struct {
+	app *app.App
+}
+
+func NewOAuthController(app *app.App) *oauthController {
+	return &oauthController{
+		app: app,
+	}
+}
+
+// startSSOSession remembers user signed in during /oauth/authorize, SameSite=Lax
+// lets browser send the cookie when client app navigates to authorize endpoint
+func startSSOSession(app *app.App, ctx *gin.Context, provider string, amr []string, user *store.User, session *store.UserSession) error {
+	id, err := generateRandomString(32)
+
+	if err != nil {
+		return err
+	}
+
+	err = app.Store.SSOSession.CreateSession(ctx.Request.Context(), id, &store.SSOSession{
+		UserID:    user.ID,
+		SessionID: session.ID,
+		Provider:  provider,
+		CreatedAt: time.Now(),
+		AMR:       amr,
+	}, SSOSessionTTL)
+
+	if err != nil {
+		return err
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(SSOSessionCookieName, id, int(SSOSessionTTL.Seconds()), SSOSessionCookiePath, "", true, true)
+
+	return nil
+}
+
+// currentSSOSession returns sso session of browser, it ends together
+// with user session it was created with, e.g. on sign-out
+func (controller *oauthController) currentSSOSession(ctx *gin.Context) (*store.SSOSession, error) {
+	id, err := ctx.Cookie(SSOSessionCookieName)
+
+	if err != nil || id == "" {
+		return nil, fmt.Errorf("sso session cookie is missing")
+	}
+
+	ssoSession, err := controller.app.Store.SSOSession.GetSession(ctx.Request.Context(), id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = controller.app.Store.Session.GetSessionBy(ctx.Request.Context(), map[string]any{
+		"id":      ssoSession.SessionID,
+		"user_id": ssoSession.UserID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ssoSession, nil
+}
+
+type authorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Prompt              string `form:"prompt"`
+	Nonce               string `form:"nonce"`
+	// seconds since upstream sign-in after which user must sign in again
+	MaxAge string `form:"max_age"`
+	// upstream provider user signs in with, chooser is shown when empty
+	Provider string `form:"provider"`
+	// set by sign-in callback when upstream sign-in failed
+	Error string `form:"error"`
+}
+
+// grantedScopes returns requested scopes, all client scopes when none are requested
+func grantedScopes(client *store.OAuthClient, scope string) ([]string, *response.OAuthError) {
+	requested := strings.Fields(scope)
+
+	if len(requested) == 0 {
+		return client.Scopes, nil
+	}
+
+	scopes := make([]string, 0, len(requested))
+
+	for _, item := range requested {
+		if !slices.Contains(client.Scopes, item) {
+			return nil, response.OAuthInvalidScope.WithDescription("scope " + item + " is not allowed for client")
+		}
+
+		if !slices.Contains(scopes, item) {
+			scopes = append(scopes, item)
+		}
+	}
+
+	return scopes, nil
+}
+
+// isValidCodeChallenge checks S256 challenge, base64url sha256 is always 43 characters
+func isValidCodeChallenge(challenge string) bool {
+	if len(challenge) != 43 {
+		return false
+	}
+
+	return !strings.ContainsFunc(challenge, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	})
+}
+
+// redirectError sends error to client redirect uri (RFC 6749 section 4.1.2.1)
+func redirectError(ctx *gin.Context, req *authorizeRequest, oauthErr *response.OAuthError) {
+	params := url.Values{"error": {oauthErr.Code}}
+
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	ctx.Redirect(http.StatusFound, withQuery(req.RedirectURI, params))
+}
+
+// @Summary		Authorize
+// @Description	OAuth 2.0 authorization code flow with PKCE for registered clients, not working in swagger.
+// @Description	User signs in with upstream provider unless browser has sso session, then code is sent to redirect_uri
+// @Tags			  oauth
+// @Param       response_type query string true "Only code is supported"
+// @Param       client_id query string true "Registered client id"
+// @Param       redirect_uri query string true "One of client redirect uris"
+// @Param       scope query string false "Space separated scopes, all client scopes when empty"
+// @Param       state query string false "Opaque value returned to client"
+// @Param       code_challenge query string false "PKCE S256 challenge, required for public clients"
+// @Param       code_challenge_method query string false "Only S256 is supported"
+// @Param       prompt query string false "none fails with login_required or consent_required instead of showing pages, consent asks trusted clients too"
+// @Param       provider query string false "Upstream provider to sign in with, chooser is shown when empty"
+// @Success     200 "Consent page of clients which are not trusted"
+// @Success     302 "Redirect to redirect_uri with code or error"
+// @Failure		  400	{object} response.OAuthError
+// @Router			/oauth/authorize [get]
+func (controller *oauthController) Authorize(ctx *gin.Context) {
+	var req authorizeRequest
+
+	if err := ctx.ShouldBindQuery(&req); err != nil || req.ClientID == "" {
+		response.RespondOAuthError(ctx, response.OAuthInvalidRequest.WithDescription("client_id is required"))
+		return
+	}
+
+	client, err := controller.app.Store.OAuthClient.GetClientBy(ctx.Request.Context(), map[string]any{"client_id": req.ClientID})
+
+	if errors.Is(err, store.ErrClientNotFound) {
+		response.RespondOAuthError(ctx, response.OAuthInvalidRequest.WithDescription("unknown client_id"))
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot get oauth client", "error", err)
+		response.RespondOAuthError(ctx, response.OAuthServerError)
+		return
+	}
+
+	// unverified redirect uri is never redirected to, so authorize cannot be used as open redirect
+	if req.RedirectURI == "" || !client.HasRedirectURI(req.RedirectURI) {
+		response.RespondOAuthError(ctx, response.OAuthInvalidRequest.WithDescription("redirect_uri is not registered for client"))
+		return
+	}
+
+	if req.ResponseType != "code" {
+		redirectError(ctx, &req, response.OAuthUnsupportedResponseType)
+		return
+	}
+
+	if !client.AllowsGrant(types.GrantAuthorizationCode) {
+		redirectError(ctx, &req, response.OAuthUnauthorizedClient)
+		return
+	}
+
+	scopes, oauthErr := grantedScopes(client, req.Scope)
+
+	if oauthErr != nil {
+		redirectError(ctx, &req, oauthErr)
+		return
+	}
+
+	if req.CodeChallenge == "" && client.IsPublic() {
+		redirectError(ctx, &req, response.OAuthInvalidRequest.WithDescription("code_challenge is required for public clients"))
+		return
+	}
+
+	if req.CodeChallenge != "" && (req.CodeChallengeMethod != "S256" || !isValidCodeChallenge(req.CodeChallenge)) {
+		redirectError(ctx, &req, response.OAuthInvalidRequest.WithDescription("only S256 code_challenge_method is supported"))
+		return
+	}
+
+	if req.Error != "" {
+		redirectError(ctx, &req, response.OAuthAccessDenied.WithDescription("upstream sign-in failed: "+req.Error))
+		return
+	}
+
+	var maxAge int
+
+	if req.MaxAge != "" {
+		maxAge, err = strconv.Atoi(req.MaxAge)
+
+		if err != nil || maxAge < 0 {
+			redirectError(ctx, &req, response.OAuthInvalidRequest.WithDescription("max_age must be non-negative number of seconds"))
+			return
+		}
+	}
+
+	ssoSession, err := controller.currentSSOSession(ctx)
+
+	if err == nil && req.MaxAge != "" && time.Since(ssoSession.CreatedAt) > time.Duration(maxAge)*time.Second {
+		err = fmt.Errorf("sso session is older than max_age")
+	}
+
+	if err != nil {
+		controller.app.Logger.Debug("no sso session", "error", err)
+
+		if req.Prompt == "none" {
+			redirectError(ctx, &req, response.OAuthLoginRequired)
+			return
+		}
+
+		authorizeURL := controller.app.Config.OAuthIssuer + "/authorize?" + ctx.Request.URL.RawQuery
+
+		if oauthErr := controller.signInUpstream(ctx, authorizeURL, req.Provider, client.Name); oauthErr != nil {
+			redirectError(ctx, &req, oauthErr)
+		}
+
+		return
+	}
+
+	// users approve each authorization of third-party clients, trusted first-party clients authorize silently
+	if !client.Trusted || req.Prompt == "consent" {
+		if req.Prompt == "none" {
+			redirectError(ctx, &req, response.OAuthConsentRequired)
+			return
+		}
+
+		page := &consentPage{
+			Client:    client.Name,
+			Scopes:    scopes,
+			CSRFToken: consentCSRFToken(ctx),
+		}
+
+		if ctx.Request.Method != http.MethodPost {
+			renderConsentPage(ctx, http.StatusOK, page)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(ctx.PostForm("csrf_token")), []byte(page.CSRFToken)) != 1 {
+			controller.app.Logger.Info("consent with invalid csrf token", "client_id", client.ClientID, "user_id", ssoSession.UserID)
+			page.Error = "Request has expired, please try again"
+			renderConsentPage(ctx, http.StatusForbidden, page)
+			return
+		}
+
+		if ctx.PostForm("action") != "allow" {
+			controller.app.Logger.Info("authorization denied by user", "client_id", client.ClientID, "user_id", ssoSession.UserID)
+			redirectError(ctx, &req, response.OAuthAccessDenied.WithDescription("user denied authorization"))
+			return
+		}
+	}
+
+	code, err := generateRandomString(32)
+
+	if err == nil {
+		err = controller.app.Store.AuthorizationCode.CreateCode(ctx.Request.Context(), code, &store.AuthorizationCode{
+			ClientID:      client.ClientID,
+			RedirectURI:   req.RedirectURI,
+			Scopes:        scopes,
+			UserID:        ssoSession.UserID,
+			SessionID:     ssoSession.SessionID,
+			CodeChallenge: req.CodeChallenge,
+			CreatedAt:     time.Now(),
+			Nonce:         req.Nonce,
+			AuthTime:      ssoSession.CreatedAt,
+			Provider:      ssoSession.Provider,
+			AMR:           ssoSession.AMR,
+		}, AuthorizationCodeTTL)
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot create authorization code", "error", err)
+		redirectError(ctx, &req, response.OAuthServerError)
+		return
+	}
+
+	controller.app.Logger.Info("authorization code issued", "client_id", client.ClientID, "user_id", ssoSession.UserID)
+
+	params := url.Values{"code": {code}}
+
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	ctx.Redirect(http.StatusFound, withQuery(req.RedirectURI, params))
+}
+
+// @Summary		Authorize Consent
+// @Description	Decision of consent page, posted to authorize url with the same query, not working in swagger
+// @Tags			  oauth
+// @Accept			x-www-form-urlencoded
+// @Param       action formData string true "allow or deny"
+// @Param       csrf_token formData string true "Token of consent page"
+// @Success     302 "Redirect to redirect_uri with code or access_denied"
+// @Failure		  400	{object} response.OAuthError
+// @Router			/oauth/authorize [post]
+func (controller *oauthController) AuthorizeConsent(ctx *gin.Context) {
+	controller.Authorize(ctx)
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize</title></head>
+<body>
+<h1>Allow {{.Client}} to access your account?</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{if .Scopes}}<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>{{end}}
+<form method="post">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit" name="action" value="allow">Allow</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+</body>
+</html>`))
+
+type consentPage struct {
+	Client    string
+	Scopes    []string
+	CSRFToken string
+	Error     string
+}
+
+// consentCSRFToken binds consent form to sso session of browser and authorize request,
+// the token is derived from HttpOnly sso cookie, so other sites cannot forge it
+func consentCSRFToken(ctx *gin.Context) string {
+	ssoSessionID, _ := ctx.Cookie(SSOSessionCookieName)
+
+	return hashBinding("consent:" + ssoSessionID + ":" + ctx.Request.URL.RawQuery)
+}
+
+// renderConsentPage shows consent page, it may not be framed so users cannot be tricked into clicking allow
+func renderConsentPage(ctx *gin.Context, status int, page *consentPage) {
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("X-Frame-Options", "DENY")
+	ctx.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	ctx.Status(status)
+	consentTemplate.Execute(ctx.Writer, page)
+}
+
+var providerChooserTemplate = template.Must(template.New("providers").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{.Client}}</h1>
+<ul>
+{{range .Providers}}<li><a href="{{.URL}}">Continue with {{.Name}}</a></li>
+{{end}}</ul>
+</body>
+</html>`))
+
+// signInUpstream sends browser to upstream provider, sign-in callback starts sso session
+// and returns to returnTo. Chooser is shown when provider is not selected
+func (controller *oauthController) signInUpstream(ctx *gin.Context, returnTo string, provider string, clientName string) *response.OAuthError {
+	providers := controller.app.Services.OAuth.EnabledProviders()
+
+	if provider == "" && len(providers) == 1 {
+		provider = providers[0]
+	}
+
+	if provider == "" {
+		type choice struct {
+			Name string
+			URL  string
+		}
+
+		choices := make([]choice, 0, len(providers))
+
+		for _, name := range providers {
+			choices = append(choices, choice{Name: name, URL: withQuery(returnTo, url.Values{"provider": {name}})})
+		}
+
+		ctx.Header("Content-Type", "text/html; charset=utf-8")
+		ctx.Status(http.StatusOK)
+		providerChooserTemplate.Execute(ctx.Writer, map[string]any{"Client": clientName, "Providers": choices})
+		return nil
+	}
+
+	signInURL, apiErr := startSignIn(controller.app, ctx, provider, &signInRequest{
+		ReturnTo: returnTo,
+		Delivery: types.LoginDeliverySSO,
+	})
+
+	switch apiErr {
+	case nil:
+		ctx.Redirect(http.StatusFound, signInURL)
+		return nil
+	case response.ErrProviderUnavailable:
+		return response.OAuthTemporarilyUnavailable
+	case response.ErrInternalServerError:
+		return response.OAuthServerError
+	default:
+		return response.OAuthInvalidRequest.WithDescription("cannot sign in with provider " + provider)
+	}
+}
+
+// OAuthGrantDevicePrefix marks user sessions created for oauth client grants
+const OAuthGrantDevicePrefix = "oauth:"
+
+type tokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	DeviceCode   string `form:"device_code"`
+	Scope        string `form:"scope"`
+	// client_secret_post authentication, public clients send only client_id
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type" example:"Bearer"`
+	ExpiresIn    int    `json:"expires_in" example:"3600"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// issued by authorization_code grant when openid scope is granted
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// authenticateClient authenticates client with client_secret_basic or client_secret_post,
+// public clients have no secret and are identified by client_id only
+func (controller *oauthController) authenticateClient(ctx *gin.Context, clientID string, clientSecret string) (*store.OAuthClient, *response.OAuthError) {
+	basicID, basicSecret, basic := ctx.Request.BasicAuth()
+
+	if basic {
+		// credentials are form encoded before base64 (RFC 6749 section 2.3.1)
+		basicID, _ = url.QueryUnescape(basicID)
+		basicSecret, _ = url.QueryUnescape(basicSecret)
+
+		if clientSecret != "" || (clientID != "" && clientID != basicID) {
+			return nil, response.OAuthInvalidRequest.WithDescription("client must use only one authentication method")
+		}
+
+		clientID, clientSecret = basicID, basicSecret
+	}
+
+	if clientID == "" {
+		return nil, response.OAuthInvalidClient
+	}
+
+	client, err := controller.app.Store.OAuthClient.GetClientBy(ctx.Request.Context(), map[string]any{"client_id": clientID})
+
+	if errors.Is(err, store.ErrClientNotFound) {
+		return nil, response.OAuthInvalidClient
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot get oauth client", "error", err)
+		return nil, response.OAuthServerError
+	}
+
+	if client.IsPublic() && clientSecret == "" {
+		return client, nil
+	}
+
+	if !client.VerifySecret(clientSecret) {
+		controller.app.Logger.Info("invalid oauth client secret", "client_id", clientID)
+		return nil, response.OAuthInvalidClient
+	}
+
+	return client, nil
+}
+
+// queryCredentials are token endpoint parameters which must not leak to logs and browser history
+var queryCredentials = []string{"client_secret", "code", "code_verifier", "refresh_token", "device_code", "token"}
+
+// bindPostForm binds parameters of form encoded request body only (RFC 6749 section 3.2),
+// query parameters are ignored and credentials in query string are rejected
+func bindPostForm(ctx *gin.Context, req any) *response.OAuthError {
+	query := ctx.Request.URL.Query()
+
+	for _, name := range queryCredentials {
+		if query.Has(name) {
+			return response.OAuthInvalidRequest.WithDescription(name + " must be sent in request body")
+		}
+	}
+
+	if err := ctx.ShouldBindWith(req, binding.FormPost); err != nil {
+		return response.OAuthInvalidRequest
+	}
+
+	return nil
+}
+
+// respondClientError responds with client authentication error,
+// basic authentication failure is challenged as required by RFC 6749 section 5.2
+func respondClientError(ctx *gin.Context, oauthErr *response.OAuthError) {
+	if _, _, basic := ctx.Request.BasicAuth(); basic && oauthErr.Status == http.StatusUnauthorized {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	response.RespondOAuthError(ctx, oauthErr)
+}
+
+// issueTokens issues tokens of user grant, refresh token is issued
+// only when client is allowed to use refresh_token grant
+func (controller *oauthController) issueTokens(client *store.OAuthClient, user *store.User, sessionID int, scopes []string) (*tokenResponse, error) {
+	claims := issuerservice.TokenClaims{
+		ClientID:  client.ClientID,
+		Scope:     strings.Join(scopes, " "),
+		SessionID: sessionID,
+		Roles:     user.Roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.Itoa(user.ID),
+		},
+	}
+
+	accessToken, err := controller.app.Services.Issuer.IssueAccessToken(claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(issuerservice.AccessTokenTTL.Seconds()),
+		Scope:       claims.Scope,
+	}
+
+	if client.AllowsGrant(types.GrantRefreshToken) {
+		tokens.RefreshToken, err = controller.app.Services.Issuer.IssueRefreshToken(claims)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tokens, nil
+}
+
+// exchangeAuthorizationCode swaps code issued by /oauth/authorize for tokens
+func (controller *oauthController) exchangeAuthorizationCode(ctx *gin.Context, client *store.OAuthClient, req *tokenRequest) (*tokenResponse, *response.OAuthError) {
+	code, err := controller.app.Store.AuthorizationCode.ConsumeCode(ctx.Request.Context(), req.Code)
+
+	if err != nil {
+		controller.app.Logger.Info("invalid authorization code", "client_id", client.ClientID, "error", err)
+		return nil, response.OAuthInvalidGrant.WithDescription("authorization code is invalid or expired")
+	}
+
+	if code.ClientID != client.ClientID {
+		return nil, response.OAuthInvalidGrant.WithDescription("authorization code was issued to another client")
+	}
+
+	if code.RedirectURI != req.RedirectURI {
+		return nil, response.OAuthInvalidGrant.WithDescription("redirect_uri does not match authorization request")
+	}
+
+	if code.CodeChallenge == "" && req.CodeVerifier != "" {
+		return nil, response.OAuthInvalidGrant.WithDescription("code_verifier is sent without code_challenge")
+	}
+
+	if code.CodeChallenge != "" && subtle.ConstantTimeCompare([]byte(oauth2.S256ChallengeFromVerifier(req.CodeVerifier)), []byte(code.CodeChallenge)) != 1 {
+		return nil, response.OAuthInvalidGrant.WithDescription("code_verifier does not match code_challenge")
+	}
+
+	return controller.grantUserTokens(ctx, client, &userGrant{
+		UserID:    code.UserID,
+		SessionID: code.SessionID,
+		Scopes:    code.Scopes,
+		Nonce:     code.Nonce,
+		AuthTime:  code.AuthTime,
+		AMR:       code.AMR,
+	})
+}
+
+// userGrant is user consent given in browser sso session, by authorize or device verification
+type userGrant struct {
+	UserID    int
+	SessionID int
+	Scopes    []string
+	Nonce     string
+	AuthTime  time.Time
+	// amr of upstream sign-in, id token has no amr when it is empty
+	AMR []string
+}
+
+// grantAMR returns amr of id token, "fed" (RFC 8176) marks federated sign-in
+// followed by methods reported by upstream provider. Provider names are not
+// registered amr values, so without upstream amr the claim is omitted
+func grantAMR(upstream []string) []string {
+	if len(upstream) == 0 {
+		return nil
+	}
+
+	amr := []string{"fed"}
+
+	for _, method := range upstream {
+		if !slices.Contains(amr, method) {
+			amr = append(amr, method)
+		}
+	}
+
+	return amr
+}
+
+// grantUserTokens issues tokens of user grant, each grant gets own user session,
+// so it can be revoked without signing user out elsewhere
+func (controller *oauthController) grantUserTokens(ctx *gin.Context, client *store.OAuthClient, grant *userGrant) (*tokenResponse, *response.OAuthError) {
+	// grant is valid only while user is still signed in
+	_, err := controller.app.Store.Session.GetSessionBy(ctx.Request.Context(), map[string]any{"id": grant.SessionID, "user_id": grant.UserID})
+
+	if err != nil {
+		return nil, response.OAuthInvalidGrant.WithDescription("user session has ended")
+	}
+
+	user, err := controller.app.Store.User.GetUserBy(ctx.Request.Context(), map[string]any{"id": grant.UserID})
+
+	if err != nil {
+		return nil, response.OAuthInvalidGrant.WithDescription("user not found")
+	}
+
+	session, err := controller.app.Store.Session.CreateSession(ctx.Request.Context(), &store.UserSessionDto{
+		UserID:    user.ID,
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		DeviceID:  OAuthGrantDevicePrefix + client.ClientID,
+	})
+
+	if err != nil {
+		controller.app.Logger.Error("cannot create grant session", "error", err)
+		return nil, response.OAuthServerError
+	}
+
+	tokens, err := controller.issueTokens(client, user, session.ID, grant.Scopes)
+
+	if err == nil && slices.Contains(grant.Scopes, types.ScopeOpenID) {
+		tokens.IDToken, err = controller.app.Services.Issuer.IssueIDToken(issuerservice.IDTokenClaims{
+			ClientID: client.ClientID,
+			Nonce:    grant.Nonce,
+			AuthTime: jwt.NewNumericDate(grant.AuthTime),
+			AMR:      grantAMR(grant.AMR),
+			ACR:      issuerservice.ACRFederated,
+			UserInfo: userInfo(user, grant.Scopes),
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: strconv.Itoa(user.ID),
+			},
+		})
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot issue tokens", "error", err)
+		return nil, response.OAuthServerError
+	}
+
+	return tokens, nil
+}
+
+// refreshTokens issues new tokens of the same grant, scope can only be narrowed
+func (controller *oauthController) refreshTokens(ctx *gin.Context, client *store.OAuthClient, req *tokenRequest) (*tokenResponse, *response.OAuthError) {
+	claims, err := controller.app.Services.Issuer.VerifyRefreshToken(req.RefreshToken)
+
+	if err != nil {
+		controller.app.Logger.Info("invalid refresh token", "client_id", client.ClientID, "error", err)
+		return nil, response.OAuthInvalidGrant.WithDescription("refresh token is invalid or expired")
+	}
+
+	if claims.ClientID != client.ClientID {
+		return nil, response.OAuthInvalidGrant.WithDescription("refresh token was issued to another client")
+	}
+
+	// scopes removed from client since are not granted anymore
+	scopes := slices.DeleteFunc(strings.Fields(claims.Scope), func(scope string) bool {
+		return !slices.Contains(client.Scopes, scope)
+	})
+
+	if req.Scope != "" {
+		requested := strings.Fields(req.Scope)
+
+		for _, scope := range requested {
+			if !slices.Contains(scopes, scope) {
+				return nil, response.OAuthInvalidScope.WithDescription("scope " + scope + " was not granted")
+			}
+		}
+
+		scopes = requested
+	}
+
+	session, err := controller.app.Store.Session.GetSessionBy(ctx.Request.Context(), map[string]any{"id": claims.SessionID})
+
+	if err != nil {
+		return nil, response.OAuthInvalidGrant.WithDescription("grant is revoked")
+	}
+
+	user, err := controller.app.Store.User.GetUserBy(ctx.Request.Context(), map[string]any{"id": session.UserID})
+
+	if err != nil {
+		return nil, response.OAuthInvalidGrant.WithDescription("user not found")
+	}
+
+	tokens, err := controller.issueTokens(client, user, session.ID, scopes)
+
+	if err != nil {
+		controller.app.Logger.Error("cannot issue tokens", "error", err)
+		return nil, response.OAuthServerError
+	}
+
+	return tokens, nil
+}
+
+// clientCredentials issues access token of client itself, refresh token is not issued
+func (controller *oauthController) clientCredentials(client *store.OAuthClient, req *tokenRequest) (*tokenResponse, *response.OAuthError) {
+	if client.IsPublic() {
+		return nil, response.OAuthUnauthorizedClient
+	}
+
+	scopes, oauthErr := grantedScopes(client, req.Scope)
+
+	if oauthErr != nil {
+		return nil, oauthErr
+	}
+
+	claims := issuerservice.TokenClaims{
+		ClientID: client.ClientID,
+		Scope:    strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: client.ClientID,
+		},
+	}
+
+	accessToken, err := controller.app.Services.Issuer.IssueAccessToken(claims)
+
+	if err != nil {
+		controller.app.Logger.Error("cannot issue client token", "error", err)
+		return nil, response.OAuthServerError
+	}
+
+	return &tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(issuerservice.AccessTokenTTL.Seconds()),
+		Scope:       claims.Scope,
+	}, nil
+}
+
+// @Summary		Token
+// @Description	OAuth 2.0 token endpoint (RFC 6749) with authorization_code (PKCE), refresh_token, client_credentials and device_code (RFC 8628) grants.
+// @Description	Clients authenticate with client_secret_basic or client_secret_post, public clients send only client_id
+// @Tags			  oauth
+// @Accept			x-www-form-urlencoded
+// @Produce		  json
+// @Param       grant_type formData string true "authorization_code, refresh_token, client_credentials or urn:ietf:params:oauth:grant-type:device_code"
+// @Param       code formData string false "Authorization code"
+// @Param       redirect_uri formData string false "Redirect uri of authorization request"
+// @Param       code_verifier formData string false "PKCE code verifier"
+// @Param       refresh_token formData string false "Refresh token"
+// @Param       device_code formData string false "Device code of device authorization"
+// @Param       scope formData string false "Space separated scopes"
+// @Param       client_id formData string false "Client id, when not sent with basic authentication"
+// @Param       client_secret formData string false "Client secret, when not sent with basic authentication"
+// @Success     200 {object} tokenResponse
+// @Failure		  400	{object} response.OAuthError
+// @Failure		  401	{object} response.OAuthError
+// @Router			/oauth/token [post]
+func (controller *oauthController) Token(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	var req tokenRequest
+
+	if oauthErr := bindPostForm(ctx, &req); oauthErr != nil {
+		response.RespondOAuthError(ctx, oauthErr)
+		return
+	}
+
+	client, oauthErr := controller.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+
+	if oauthErr != nil {
+		respondClientError(ctx, oauthErr)
+		return
+	}
+
+	if !slices.Contains(types.GrantTypes, req.GrantType) {
+		response.RespondOAuthError(ctx, response.OAuthUnsupportedGrantType)
+		return
+	}
+
+	if !client.AllowsGrant(req.GrantType) {
+		response.RespondOAuthError(ctx, response.OAuthUnauthorizedClient)
+		return
+	}
+
+	var tokens *tokenResponse
+
+	switch req.GrantType {
+	case types.GrantAuthorizationCode:
+		tokens, oauthErr = controller.exchangeAuthorizationCode(ctx, client, &req)
+	case types.GrantRefreshToken:
+		tokens, oauthErr = controller.refreshTokens(ctx, client, &req)
+	case types.GrantClientCredentials:
+		tokens, oauthErr = controller.clientCredentials(client, &req)
+	case types.GrantDeviceCode:
+		tokens, oauthErr = controller.exchangeDeviceCode(ctx, client, &req)
+	}
+
+	if oauthErr != nil {
+		response.RespondOAuthError(ctx, oauthErr)
+		return
+	}
+
+	controller.app.Logger.Info("oauth tokens issued", "client_id", client.ClientID, "grant_type", req.GrantType)
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// userInfo returns user claims released by granted scopes
+func userInfo(user *store.User, scopes []string) issuerservice.UserInfo {
+	var info issuerservice.UserInfo
+
+	if slices.Contains(scopes, types.ScopeProfile) {
+		if user.Name != nil {
+			info.Name = *user.Name
+		}
+
+		if user.AvatarURL != nil {
+			info.Picture = *user.AvatarURL
+		}
+	}
+
+	if slices.Contains(scopes, types.ScopeEmail) {
+		info.Email = user.Email
+		info.EmailVerified = &user.IsEmailVerified
+	}
+
+	return info
+}
+
+type userInfoResponse struct {
+	Subject string `json:"sub" example:"42"`
+	issuerservice.UserInfo
+}
+
+// bearerError responds with error and WWW-Authenticate challenge of RFC 6750
+func bearerError(ctx *gin.Context, oauthErr *response.OAuthError) {
+	ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q`, oauthErr.Code))
+	response.RespondOAuthError(ctx, oauthErr)
+}
+
+// @Summary		UserInfo
+// @Description	OpenID Connect userinfo of access token issued with openid scope, claims are filtered by profile and email scopes
+// @Tags			  oauth
+// @Produce		  json
+// @Param       Authorization header string true "Bearer access token"
+// @Success     200 {object} userInfoResponse
+// @Failure		  401	{object} response.OAuthError
+// @Failure		  403	{object} response.OAuthError
+// @Router			/oauth/userinfo [get]
+// @Router			/oauth/userinfo [post]
+func (controller *oauthController) UserInfo(ctx *gin.Context) {
+	raw, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+
+	if !found || raw == "" {
+		bearerError(ctx, response.OAuthInvalidToken.WithDescription("bearer token is missing"))
+		return
+	}
+
+	claims, tokenType, err := controller.activeToken(ctx, raw, tokenTypeAccess)
+
+	if errors.Is(err, errTokenInactive) || tokenType == tokenTypeRefresh {
+		controller.app.Logger.Debug("invalid userinfo token", "error", err)
+		bearerError(ctx, response.OAuthInvalidToken)
+		return
+	}
+
+	if err != nil {
+		controller.app.Logger.Error("cannot check userinfo token", "error", err)
+		response.RespondOAuthError(ctx, response.OAuthServerError)
+		return
+	}
+
+	scopes := strings.Fields(claims.Scope)
+
+	// client_credentials tokens have no user and no openid scope
+	if !slices.Contains(scopes, types.ScopeOpenID) || claims.SessionID == 0 {
+		bearerError(ctx, response.OAuthInsufficientScope.WithDescription("openid scope is required"))
+		return
+	}
+
+	user, err := controller.app.Store.User.GetUserBy(ctx.Request.Context(), map[string]any{"id": claims.Subject})
+
+	if err != nil {
+		bearerError(ctx, response.OAuthInvalidToken.WithDescription("user not found"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, userInfoResponse{
+		Subject:  strconv.Itoa(user.ID),
+		UserInfo: userInfo(user, scopes),
+	})
+}
+
+// openIDConfiguration is OpenID Connect discovery document
+type openIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ACRValuesSupported                []string `json:"acr_values_supported"`
+}
+
+// @Summary		OpenID configuration
+// @Description	OpenID Connect discovery document, endpoints are absolute urls under OAUTH_ISSUER
+// @Tags			  oauth
+// @Produce		  json
+// @Success     200 {object} openIDConfiguration
+// @Router			/oauth/.well-known/openid-configuration [get]
+func (controller *oauthController) OpenIDConfiguration(ctx *gin.Context) {
+	issuer := controller.app.Services.Issuer.Issuer()
+
+	ctx.JSON(http.StatusOK, openIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		DeviceAuthorizationEndpoint:       issuer + "/device_authorization",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		RevocationEndpoint:                issuer + "/revoke",
+		JWKSURI:                           issuer + "/jwks",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		ScopesSupported:                   []string{types.ScopeOpenID, types.ScopeProfile, types.ScopeEmail},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr", "acr", "name", "picture", "email", "email_verified"},
+		GrantTypesSupported:               types.GrantTypes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ACRValuesSupported:                []string{issuerservice.ACRFederated},
+	})
+}
+
+// @Summary		JWKS
+// @Description	Public keys which verify tokens issued to oauth clients
+// @Tags			  oauth
+// @Produce		  json
+// @Success     200 {object} issuerservice.JWKS
+// @Router			/oauth/jwks [get]
+func (controller *oauthController) JWKS(ctx *gin.Context) {
+	// keys change only on restart with another OAUTH_SIGNING_KEY_FILE
+	ctx.Header("Cache-Control", "public, max-age=3600")
+	ctx.JSON(http.StatusOK, controller.app.Services.Issuer.JWKS())
+}
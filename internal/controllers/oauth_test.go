@@ -0,0 +1,445 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"oauth-go/internal/app"
+	"oauth-go/internal/store"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/response"
+)
+
+const (
+	testRedirectURI  = "https://client.example.com/callback"
+	testClientSecret = "client-secret"
+	testVerifier     = "verifier-of-pkce-which-is-at-least-43-characters"
+)
+
+// seedOAuth creates oauth clients and signed in user, returns user session of grants
+func seedOAuth(t *testing.T, testApp *app.App) *store.UserSession {
+	t.Helper()
+
+	ctx := context.Background()
+	allGrants := []string{types.GrantAuthorizationCode, types.GrantRefreshToken, types.GrantClientCredentials, types.GrantDeviceCode}
+
+	clients := []*store.OAuthClientDto{
+		{ClientID: "confidential", ClientSecret: testClientSecret, ClientType: types.OAuthClientConfidential, GrantTypes: allGrants},
+		{ClientID: "public", ClientType: types.OAuthClientPublic, GrantTypes: allGrants},
+		{ClientID: "another", ClientSecret: testClientSecret, ClientType: types.OAuthClientConfidential, GrantTypes: allGrants},
+		{ClientID: "code-only", ClientSecret: testClientSecret, ClientType: types.OAuthClientConfidential, GrantTypes: []string{types.GrantAuthorizationCode}},
+	}
+
+	for _, client := range clients {
+		client.Name = client.ClientID
+		client.RedirectURIs = []string{testRedirectURI}
+		client.Scopes = []string{types.ScopeOpenID, types.ScopeProfile, types.ScopeEmail}
+
+		if _, err := testApp.Store.OAuthClient.CreateClient(ctx, client); err != nil {
+			t.Fatalf("cannot create client: %v", err)
+		}
+	}
+
+	user, err := testApp.Store.User.CreateUser(ctx, &store.UserDto{
+		Name:            "Alice",
+		Email:           "alice@example.com",
+		IsEmailVerified: true,
+		Provider:        testProvider,
+		ProviderUserID:  "1",
+	})
+
+	if err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+
+	session, err := testApp.Store.Session.CreateSession(ctx, &store.UserSessionDto{UserID: user.ID, DeviceID: "browser"})
+
+	if err != nil {
+		t.Fatalf("cannot create session: %v", err)
+	}
+
+	return session
+}
+
+// seedCode saves authorization code as authorize endpoint does after user consent
+func seedCode(t *testing.T, testApp *app.App, session *store.UserSession, code string, clientID string, challenge string) {
+	t.Helper()
+
+	err := testApp.Store.AuthorizationCode.CreateCode(context.Background(), code, &store.AuthorizationCode{
+		ClientID:      clientID,
+		RedirectURI:   testRedirectURI,
+		Scopes:        []string{types.ScopeOpenID, types.ScopeEmail},
+		UserID:        int(session.UserID),
+		SessionID:     session.ID,
+		CodeChallenge: challenge,
+		CreatedAt:     time.Now(),
+		Nonce:         "nonce",
+		AuthTime:      time.Now(),
+	}, time.Minute)
+
+	if err != nil {
+		t.Fatalf("cannot save authorization code: %v", err)
+	}
+}
+
+func postForm(testApp *app.App, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return serve(testApp, req)
+}
+
+func codeForm(code string, clientID string, verifier string) url.Values {
+	form := url.Values{
+		"grant_type":   {types.GrantAuthorizationCode},
+		"code":         {code},
+		"redirect_uri": {testRedirectURI},
+		"client_id":    {clientID},
+	}
+
+	if clientID != "public" {
+		form.Set("client_secret", testClientSecret)
+	}
+
+	if verifier != "" {
+		form.Set("code_verifier", verifier)
+	}
+
+	return form
+}
+
+// checkTokenResponse checks status and oauth error code of token endpoint response
+func checkTokenResponse(t *testing.T, recorder *httptest.ResponseRecorder, status int, errorCode string) tokenResponse {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, recorder.Code, recorder.Body.String())
+	}
+
+	if errorCode != "" {
+		var oauthErr response.OAuthError
+		decodeBody(t, recorder, &oauthErr)
+
+		if oauthErr.Code != errorCode {
+			t.Fatalf("expected error %s, got %s", errorCode, recorder.Body.String())
+		}
+
+		return tokenResponse{}
+	}
+
+	var tokens tokenResponse
+	decodeBody(t, recorder, &tokens)
+
+	if tokens.AccessToken == "" || tokens.TokenType != "Bearer" {
+		t.Fatalf("access token is not issued: %s", recorder.Body.String())
+	}
+
+	return tokens
+}
+
+func TestTokenGrants(t *testing.T) {
+	challenge := oauth2.S256ChallengeFromVerifier(testVerifier)
+
+	tests := []struct {
+		name string
+		// seed saves authorization code used by request, when set
+		seed      func(testApp *app.App, session *store.UserSession)
+		query     url.Values
+		form      url.Values
+		basicAuth []string
+		status    int
+		errorCode string
+	}{
+		{
+			name: "authorization code of confidential client",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			form:   codeForm("code", "confidential", ""),
+			status: http.StatusOK,
+		},
+		{
+			name: "authorization code with basic authentication",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			form:      url.Values{"grant_type": {types.GrantAuthorizationCode}, "code": {"code"}, "redirect_uri": {testRedirectURI}},
+			basicAuth: []string{"confidential", testClientSecret},
+			status:    http.StatusOK,
+		},
+		{
+			name: "authorization code with pkce",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "public", challenge)
+			},
+			form:   codeForm("code", "public", testVerifier),
+			status: http.StatusOK,
+		},
+		{
+			name: "another pkce verifier",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "public", challenge)
+			},
+			form:      codeForm("code", "public", testVerifier+"x"),
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name: "without pkce verifier",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "public", challenge)
+			},
+			form:      codeForm("code", "public", ""),
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name: "pkce verifier without challenge",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			form:      codeForm("code", "confidential", testVerifier),
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name: "another redirect uri",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			form: func() url.Values {
+				form := codeForm("code", "confidential", "")
+				form.Set("redirect_uri", "https://evil.example.com/callback")
+				return form
+			}(),
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name: "code of another client",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "another", "")
+			},
+			form:      codeForm("code", "confidential", ""),
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name:      "unknown code",
+			form:      codeForm("unknown", "confidential", ""),
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name: "ended user session",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+				testApp.Store.Session.DeleteSessionBy(context.Background(), map[string]any{"id": session.ID})
+			},
+			form:      codeForm("code", "confidential", ""),
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name: "another client secret",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			form: func() url.Values {
+				form := codeForm("code", "confidential", "")
+				form.Set("client_secret", "another")
+				return form
+			}(),
+			status:    http.StatusUnauthorized,
+			errorCode: "invalid_client",
+		},
+		{
+			name: "two authentication methods",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			form:      codeForm("code", "confidential", ""),
+			basicAuth: []string{"confidential", testClientSecret},
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_request",
+		},
+		{
+			name: "client secret in query",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			query:     url.Values{"client_secret": {testClientSecret}},
+			form:      url.Values{"grant_type": {types.GrantAuthorizationCode}, "code": {"code"}, "redirect_uri": {testRedirectURI}, "client_id": {"confidential"}},
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_request",
+		},
+		{
+			name: "code in query",
+			seed: func(testApp *app.App, session *store.UserSession) {
+				seedCode(t, testApp, session, "code", "confidential", "")
+			},
+			query:     url.Values{"code": {"code"}},
+			form:      url.Values{"grant_type": {types.GrantAuthorizationCode}, "redirect_uri": {testRedirectURI}, "client_id": {"confidential"}, "client_secret": {testClientSecret}},
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_request",
+		},
+		{
+			name:   "client credentials",
+			form:   url.Values{"grant_type": {types.GrantClientCredentials}, "client_id": {"confidential"}, "client_secret": {testClientSecret}, "scope": {types.ScopeProfile}},
+			status: http.StatusOK,
+		},
+		{
+			name:      "client credentials of public client",
+			form:      url.Values{"grant_type": {types.GrantClientCredentials}, "client_id": {"public"}},
+			status:    http.StatusBadRequest,
+			errorCode: "unauthorized_client",
+		},
+		{
+			name:      "client credentials with scope not allowed",
+			form:      url.Values{"grant_type": {types.GrantClientCredentials}, "client_id": {"confidential"}, "client_secret": {testClientSecret}, "scope": {"admin"}},
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_scope",
+		},
+		{
+			name:      "grant not allowed for client",
+			form:      url.Values{"grant_type": {types.GrantClientCredentials}, "client_id": {"code-only"}, "client_secret": {testClientSecret}},
+			status:    http.StatusBadRequest,
+			errorCode: "unauthorized_client",
+		},
+		{
+			name:      "unsupported grant",
+			form:      url.Values{"grant_type": {"password"}, "client_id": {"confidential"}, "client_secret": {testClientSecret}},
+			status:    http.StatusBadRequest,
+			errorCode: "unsupported_grant_type",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testApp, _ := newTestApp(t, "")
+			session := seedOAuth(t, testApp)
+
+			if test.seed != nil {
+				test.seed(testApp, session)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/oauth/token", strings.NewReader(test.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if len(test.query) > 0 {
+				req.URL.RawQuery = test.query.Encode()
+			}
+
+			if test.basicAuth != nil {
+				req.SetBasicAuth(test.basicAuth[0], test.basicAuth[1])
+			}
+
+			tokens := checkTokenResponse(t, serve(testApp, req), test.status, test.errorCode)
+
+			if test.status == http.StatusOK && test.form.Get("grant_type") == types.GrantAuthorizationCode && (tokens.IDToken == "" || tokens.RefreshToken == "") {
+				t.Fatalf("id token and refresh token are not issued: %+v", tokens)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeIsSingleUse(t *testing.T) {
+	testApp, _ := newTestApp(t, "")
+	session := seedOAuth(t, testApp)
+	seedCode(t, testApp, session, "code", "confidential", "")
+
+	checkTokenResponse(t, postForm(testApp, "/api/v1/oauth/token", codeForm("code", "confidential", "")), http.StatusOK, "")
+	checkTokenResponse(t, postForm(testApp, "/api/v1/oauth/token", codeForm("code", "confidential", "")), http.StatusBadRequest, "invalid_grant")
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		scope    string
+		// tamper changes refresh token issued to confidential client
+		tamper    func(refreshToken string) string
+		revoke    bool
+		status    int
+		errorCode string
+	}{
+		{
+			name:     "refresh token",
+			clientID: "confidential",
+			status:   http.StatusOK,
+		},
+		{
+			name:     "narrowed scope",
+			clientID: "confidential",
+			scope:    types.ScopeEmail,
+			status:   http.StatusOK,
+		},
+		{
+			name:      "widened scope",
+			clientID:  "confidential",
+			scope:     types.ScopeProfile,
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_scope",
+		},
+		{
+			name:      "refresh token of another client",
+			clientID:  "another",
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name:      "invalid refresh token",
+			clientID:  "confidential",
+			tamper:    func(refreshToken string) string { return refreshToken + "x" },
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+		{
+			name:      "revoked grant",
+			clientID:  "confidential",
+			revoke:    true,
+			status:    http.StatusBadRequest,
+			errorCode: "invalid_grant",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testApp, db := newTestApp(t, "")
+			session := seedOAuth(t, testApp)
+			seedCode(t, testApp, session, "code", "confidential", "")
+
+			tokens := checkTokenResponse(t, postForm(testApp, "/api/v1/oauth/token", codeForm("code", "confidential", "")), http.StatusOK, "")
+			refreshToken := tokens.RefreshToken
+
+			if test.tamper != nil {
+				refreshToken = test.tamper(refreshToken)
+			}
+
+			if test.revoke {
+				// each grant has own session, ending it revokes the grant only
+				grantSession := db.sessions[len(db.sessions)-1]
+				testApp.Store.Session.DeleteSessionBy(context.Background(), map[string]any{"id": grantSession.ID})
+			}
+
+			refreshed := checkTokenResponse(t, postForm(testApp, "/api/v1/oauth/token", url.Values{
+				"grant_type":    {types.GrantRefreshToken},
+				"refresh_token": {refreshToken},
+				"client_id":     {test.clientID},
+				"client_secret": {testClientSecret},
+				"scope":         {test.scope},
+			}), test.status, test.errorCode)
+
+			if test.status == http.StatusOK && test.scope != "" && refreshed.Scope != test.scope {
+				t.Fatalf("expected scope %s, got %s", test.scope, refreshed.Scope)
+			}
+		})
+	}
+}
@@ -13,9 +13,17 @@ import (
 
 const contextUserKey = "user"
 
+// AccessTokenCookieName is set when tokens are delivered with cookies
+const AccessTokenCookieName = "access_token"
+
+// getTokenFromHeader returns bearer token, falls back to access token cookie
 func getTokenFromHeader(ctx *gin.Context) (string, error) {
 	authHeader := ctx.GetHeader("Authorization")
 	if authHeader == "" {
+		if cookie, err := ctx.Cookie(AccessTokenCookieName); err == nil && cookie != "" {
+			return cookie, nil
+		}
+
 		return "", fmt.Errorf("missing Authorization token")
 	}
 
@@ -30,6 +38,10 @@ func getTokenFromHeader(ctx *gin.Context) (string, error) {
 	return tokenString, nil
 }
 
+// AuthMiddleware authenticates first-party tokens of our own API. They are revoked
+// by ending their session, which is checked on each request. Denylist of revoked
+// oauth tokens applies only to issuer tokens at /oauth/introspect and /oauth/userinfo,
+// first-party tokens are never accepted there and issuer tokens never here
 func AuthMiddleware(store *store.Store, services *services.Services, logger *slog.Logger) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		tokenString, err := getTokenFromHeader(ctx)
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"oauth-go/pkg/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+const InternalAPIKeyHeader = "X-Internal-Api-Key"
+
+// InternalAuthMiddleware allows only requests of internal services
+// which send one of configured api keys
+func InternalAuthMiddleware(apiKeys []string, logger *slog.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		apiKey := ctx.GetHeader(InternalAPIKeyHeader)
+
+		if apiKey == "" {
+			logger.Debug("missing internal api key")
+			ctx.AbortWithStatusJSON(response.ErrUnauthorized.Code, response.ErrUnauthorized)
+			return
+		}
+
+		for _, allowed := range apiKeys {
+			if subtle.ConstantTimeCompare([]byte(apiKey), []byte(allowed)) == 1 {
+				ctx.Next()
+				return
+			}
+		}
+
+		logger.Info("invalid internal api key", "ip", ctx.ClientIP())
+		ctx.AbortWithStatusJSON(response.ErrUnauthorized.Code, response.ErrUnauthorized)
+	}
+}
@@ -0,0 +1,139 @@
+package claimsservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	ouathservice "oauth-go/internal/services/oauth"
+	"oauth-go/internal/types"
+	"os"
+	"slices"
+	"strings"
+)
+
+type ClaimsService interface {
+	Enabled() bool
+	Map(provider string, profile *ouathservice.ProfileImpl) ([]string, map[string]string)
+}
+
+// Rule grants roles and attributes to users matching all non empty conditions
+type Rule struct {
+	// provider name, any provider when empty
+	Provider string `json:"provider"`
+	// github organization login
+	Organization string `json:"organization"`
+	// github team as org/team-slug
+	Team string `json:"team"`
+	// google group email
+	Group string `json:"group"`
+	// google workspace domain
+	HostedDomain string `json:"hosted_domain"`
+	// domain of verified email
+	EmailDomain string `json:"email_domain"`
+
+	Roles      []string          `json:"roles"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type Claims struct {
+	rules []Rule
+}
+
+// New loads rules from CLAIMS_MAPPING_FILE, mapping is disabled when file is not set
+func New(config *types.AppConfig) (*Claims, error) {
+	claims := &Claims{}
+
+	if config.ClaimsMappingFile == "" {
+		return claims, nil
+	}
+
+	data, err := os.ReadFile(config.ClaimsMappingFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read claims mapping file: %w", err)
+	}
+
+	err = json.Unmarshal(data, &claims.rules)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse claims mapping file: %w", err)
+	}
+
+	for i, rule := range claims.rules {
+		if len(rule.Roles) == 0 && len(rule.Attributes) == 0 {
+			return nil, fmt.Errorf("claims mapping rule %d grants neither roles nor attributes", i)
+		}
+	}
+
+	return claims, nil
+}
+
+// Enabled reports whether any rules are configured,
+// user roles and attributes are not touched otherwise
+func (claims *Claims) Enabled() bool {
+	return len(claims.rules) > 0
+}
+
+// Map returns sorted roles and attributes granted by matching rules,
+// attributes of later rules override earlier ones
+func (claims *Claims) Map(provider string, profile *ouathservice.ProfileImpl) ([]string, map[string]string) {
+	roles := []string{}
+	attributes := map[string]string{}
+
+	for _, rule := range claims.rules {
+		if !rule.matches(provider, profile) {
+			continue
+		}
+
+		for _, role := range rule.Roles {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+
+		maps.Copy(attributes, rule.Attributes)
+	}
+
+	slices.Sort(roles)
+
+	return roles, attributes
+}
+
+func (rule *Rule) matches(provider string, profile *ouathservice.ProfileImpl) bool {
+	if rule.Provider != "" && rule.Provider != provider {
+		return false
+	}
+
+	if rule.Organization != "" && !containsFold(profile.Organizations, rule.Organization) {
+		return false
+	}
+
+	if rule.Team != "" && !containsFold(profile.Teams, rule.Team) {
+		return false
+	}
+
+	if rule.Group != "" && !containsFold(profile.Groups, rule.Group) {
+		return false
+	}
+
+	if rule.HostedDomain != "" && !strings.EqualFold(profile.HostedDomain, rule.HostedDomain) {
+		return false
+	}
+
+	if rule.EmailDomain != "" {
+		domain := profile.Email[strings.LastIndex(profile.Email, "@")+1:]
+
+		if !profile.EmailVerified || !strings.EqualFold(domain, rule.EmailDomain) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsFold reports whether list contains value ignoring case
+func containsFold(list []string, value string) bool {
+	return slices.ContainsFunc(list, func(item string) bool {
+		return strings.EqualFold(item, value)
+	})
+}
@@ -0,0 +1,57 @@
+package eventsservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// events are published to redis channel events:<type>
+const channelPrefix = "events:"
+
+const (
+	UserProfileUpdated = "user.profile_updated"
+)
+
+type EventsService interface {
+	Publish(ctx context.Context, eventType string, data any) error
+}
+
+type Event struct {
+	Type      string    `json:"type"`
+	Data      any       `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Events struct {
+	rdb *redis.Client
+}
+
+func New(rdb *redis.Client) *Events {
+	return &Events{
+		rdb: rdb,
+	}
+}
+
+func (events *Events) Publish(ctx context.Context, eventType string, data any) error {
+	payload, err := json.Marshal(&Event{
+		Type:      eventType,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+
+	if err != nil {
+		return fmt.Errorf("cannot encode event: %w", err)
+	}
+
+	err = events.rdb.Publish(ctx, channelPrefix+eventType, payload).Err()
+
+	if err != nil {
+		return fmt.Errorf("cannot publish event: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package issuerservice
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"oauth-go/internal/types"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessTokenTTL  = time.Hour
+	RefreshTokenTTL = 7 * 24 * time.Hour
+	IDTokenTTL      = time.Hour
+
+	// typ header of access tokens (RFC 9068), refresh tokens cannot be used in their place
+	accessTokenType  = "at+jwt"
+	refreshTokenType = "refresh+jwt"
+	idTokenType      = "JWT"
+)
+
+// ACRFederated is acr of id tokens, users always sign in with upstream provider
+// and its authentication strength is not known to this service
+const ACRFederated = "urn:oauth-go:acr:federated"
+
+type IssuerService interface {
+	IssueAccessToken(claims TokenClaims) (string, error)
+	IssueRefreshToken(claims TokenClaims) (string, error)
+	IssueIDToken(claims IDTokenClaims) (string, error)
+	VerifyAccessToken(raw string) (*TokenClaims, error)
+	VerifyRefreshToken(raw string) (*TokenClaims, error)
+	JWKS() JWKS
+}
+
+// TokenClaims are claims of tokens issued to oauth clients,
+// subject is user id or client id for client_credentials grant
+type TokenClaims struct {
+	ClientID string `json:"client_id"`
+	// space separated granted scopes
+	Scope string `json:"scope,omitempty"`
+	// user session of the grant, empty for client_credentials grant
+	SessionID int      `json:"session_id,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserInfo are standard claims of user, released by profile and email scopes
+type UserInfo struct {
+	Name          string `json:"name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// IDTokenClaims are claims of OpenID Connect id token, audience is client id
+type IDTokenClaims struct {
+	ClientID string `json:"-"`
+	// nonce of authorization request, echoed so client can detect replay
+	Nonce string `json:"nonce,omitempty"`
+	// time user signed in with upstream provider
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+	ACR      string           `json:"acr,omitempty"`
+	UserInfo
+	jwt.RegisteredClaims
+}
+
+// JWK is public signing key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Issuer signs tokens of authorization server with RS256,
+// so resource servers can verify them with public key
+type Issuer struct {
+	issuer string
+	key    *rsa.PrivateKey
+	keyID  string
+}
+
+// New loads signing key from OAUTH_SIGNING_KEY_FILE, random key is generated when it is not set
+func New(config *types.AppConfig, logger *slog.Logger) (*Issuer, error) {
+	var key *rsa.PrivateKey
+
+	if config.OAuthSigningKeyFile != "" {
+		data, err := os.ReadFile(config.OAuthSigningKeyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot read oauth signing key: %w", err)
+		}
+
+		key, err = jwt.ParseRSAPrivateKeyFromPEM(data)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse oauth signing key: %w", err)
+		}
+	} else {
+		logger.Warn("OAUTH_SIGNING_KEY_FILE is not set, tokens issued to oauth clients become invalid on restart")
+
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate oauth signing key: %w", err)
+		}
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode oauth signing key: %w", err)
+	}
+
+	// key id changes together with key, so clients refetch keys after rotation
+	hash := sha256.Sum256(publicKey)
+
+	return &Issuer{
+		issuer: config.OAuthIssuer,
+		key:    key,
+		keyID:  base64.RawURLEncoding.EncodeToString(hash[:12]),
+	}, nil
+}
+
+// Issuer returns issuer url of tokens
+func (issuer *Issuer) Issuer() string {
+	return issuer.issuer
+}
+
+// stamp sets registered claims shared by all issued tokens
+func (issuer *Issuer) stamp(claims *jwt.RegisteredClaims, audience string, ttl time.Duration) error {
+	jti := make([]byte, 16)
+
+	if _, err := rand.Read(jti); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	claims.Issuer = issuer.issuer
+	claims.Audience = jwt.ClaimStrings{audience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.ID = base64.RawURLEncoding.EncodeToString(jti)
+
+	return nil
+}
+
+func (issuer *Issuer) sign(tokenType string, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["typ"] = tokenType
+	token.Header["kid"] = issuer.keyID
+
+	return token.SignedString(issuer.key)
+}
+
+func (issuer *Issuer) IssueAccessToken(claims TokenClaims) (string, error) {
+	if err := issuer.stamp(&claims.RegisteredClaims, claims.ClientID, AccessTokenTTL); err != nil {
+		return "", err
+	}
+
+	return issuer.sign(accessTokenType, claims)
+}
+
+func (issuer *Issuer) IssueRefreshToken(claims TokenClaims) (string, error) {
+	if err := issuer.stamp(&claims.RegisteredClaims, claims.ClientID, RefreshTokenTTL); err != nil {
+		return "", err
+	}
+
+	return issuer.sign(refreshTokenType, claims)
+}
+
+func (issuer *Issuer) IssueIDToken(claims IDTokenClaims) (string, error) {
+	if err := issuer.stamp(&claims.RegisteredClaims, claims.ClientID, IDTokenTTL); err != nil {
+		return "", err
+	}
+
+	return issuer.sign(idTokenType, claims)
+}
+
+func (issuer *Issuer) verify(tokenType string, raw string) (*TokenClaims, error) {
+	var claims TokenClaims
+
+	token, err := jwt.ParseWithClaims(raw, &claims, func(token *jwt.Token) (any, error) {
+		if token.Header["typ"] != tokenType {
+			return nil, fmt.Errorf("unexpected token type %v", token.Header["typ"])
+		}
+
+		return &issuer.key.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(issuer.issuer),
+		jwt.WithExpirationRequired(),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return &claims, nil
+}
+
+// VerifyAccessToken checks signature, issuer and expiration of access token
+func (issuer *Issuer) VerifyAccessToken(raw string) (*TokenClaims, error) {
+	return issuer.verify(accessTokenType, raw)
+}
+
+// VerifyRefreshToken checks signature, issuer and expiration of refresh token
+func (issuer *Issuer) VerifyRefreshToken(raw string) (*TokenClaims, error) {
+	return issuer.verify(refreshTokenType, raw)
+}
+
+// JWKS returns public key which verifies issued tokens
+func (issuer *Issuer) JWKS() JWKS {
+	publicKey := issuer.key.PublicKey
+
+	return JWKS{
+		Keys: []JWK{{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodRS256.Alg(),
+			KeyID:     issuer.keyID,
+			N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}},
+	}
+}
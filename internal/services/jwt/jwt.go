@@ -27,6 +27,9 @@ type AppCustomClaims struct {
 	UserID    int    `json:"user_id"`
 	Email     string `json:"email"`
 	SessionID int    `json:"session_id"`
+	// granted by claims mapping rules, see CLAIMS_MAPPING_FILE
+	Roles      []string          `json:"roles,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 type CustomClaims struct {
@@ -34,14 +37,10 @@ type CustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-func (service *Jwt) IssueTokensPair(userID int, sessionID int, email string) (string, string) {
+func (service *Jwt) IssueTokensPair(appClaims AppCustomClaims) (string, string) {
 	// Create access token (short-lived JWT)
 	accessTokenClaims := &CustomClaims{
-		AppCustomClaims: AppCustomClaims{
-			UserID:    userID,
-			SessionID: sessionID,
-			Email:     email,
-		},
+		AppCustomClaims: appClaims,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
 		},
@@ -54,11 +53,7 @@ func (service *Jwt) IssueTokensPair(userID int, sessionID int, email string) (st
 
 	// Create refresh token (long-lived JWT)
 	refreshTokenClaims := &CustomClaims{
-		AppCustomClaims: AppCustomClaims{
-			UserID:    userID,
-			SessionID: sessionID,
-			Email:     email,
-		},
+		AppCustomClaims: appClaims,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().AddDate(0, 0, 7)),
 		},
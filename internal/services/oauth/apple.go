@@ -0,0 +1,207 @@
+package ouathservice
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/configurator"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	appleIssuer   = "https://appleid.apple.com"
+	appleAuthURL  = "https://appleid.apple.com/auth/authorize"
+	appleTokenURL = "https://appleid.apple.com/auth/token"
+	appleJWKSURL  = "https://appleid.apple.com/auth/keys"
+	// client secret is signed for each token request, so it is short-lived
+	appleClientSecretTTL = 5 * time.Minute
+)
+
+func init() {
+	Register("apple", newAppleProvider)
+}
+
+type appleConfig struct {
+	// apple developer team id
+	TeamID string `env:"TEAM_ID"`
+	// id of sign in with apple private key
+	KeyID string `env:"KEY_ID"`
+	// path to .p8 private key downloaded from apple developer account
+	PrivateKeyFile string `env:"PRIVATE_KEY_FILE"`
+}
+
+// appleBool decodes apple boolean claims which are sent either as bool or as "true" string
+type appleBool bool
+
+func (b *appleBool) UnmarshalJSON(data []byte) error {
+	*b = appleBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// AppleClaims are sign in with apple id token claims
+type AppleClaims struct {
+	Email          string    `json:"email"`
+	EmailVerified  appleBool `json:"email_verified"`
+	IsPrivateEmail appleBool `json:"is_private_email"`
+	Nonce          string    `json:"nonce"`
+	jwt.RegisteredClaims
+
+	// name is not part of id token, it is posted to callback on first authorization only
+	name string
+}
+
+func (c AppleClaims) GetID() string {
+	return c.Subject
+}
+
+func (c AppleClaims) GetEmail() string {
+	return c.Email
+}
+
+func (c AppleClaims) IsEmailVerified() bool {
+	return bool(c.EmailVerified)
+}
+
+func (c AppleClaims) GetName() string {
+	return c.name
+}
+
+func (c AppleClaims) GetAvatarURL() string {
+	return ""
+}
+
+func (c AppleClaims) GetNonce() string {
+	return c.Nonce
+}
+
+// appleUser is user json posted to callback on first authorization
+type appleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+}
+
+type appleProvider struct {
+	clientID       string
+	teamID         string
+	keyID          string
+	privateKey     *ecdsa.PrivateKey
+	verifier       *idTokenVerifier
+	nativeVerifier *idTokenVerifier
+}
+
+func newAppleProvider(config *types.ProviderConfig) (Provider, error) {
+	var apple appleConfig
+	err := configurator.Parse(&apple, config.EnvPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := os.ReadFile(apple.PrivateKeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read private key %w", err)
+	}
+
+	privateKey, err := jwt.ParseECPrivateKeyFromPEM(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key %w", err)
+	}
+
+	verifier := newIDTokenVerifier(appleIssuer, appleJWKSURL, config.ClientID)
+
+	return &appleProvider{
+		clientID:       config.ClientID,
+		teamID:         apple.TeamID,
+		keyID:          apple.KeyID,
+		privateKey:     privateKey,
+		verifier:       verifier,
+		nativeVerifier: verifier.native(config.NativeClientIDs),
+	}, nil
+}
+
+func (provider *appleProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	return oauth2.Endpoint{
+		AuthURL:   appleAuthURL,
+		TokenURL:  appleTokenURL,
+		AuthStyle: oauth2.AuthStyleInParams,
+	}, nil
+}
+
+func (provider *appleProvider) Scopes() []string {
+	return []string{"name", "email"}
+}
+
+// AuthURLOptions makes apple post callback as a form, it is required when name or email is requested
+func (provider *appleProvider) AuthURLOptions() []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("response_mode", "form_post"),
+	}
+}
+
+// ClientSecret signs ES256 client secret with .p8 key
+func (provider *appleProvider) ClientSecret() (string, error) {
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    provider.teamID,
+		Subject:   provider.clientID,
+		Audience:  jwt.ClaimStrings{appleIssuer},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(appleClientSecretTTL)),
+	})
+
+	token.Header["kid"] = provider.keyID
+
+	return token.SignedString(provider.privateKey)
+}
+
+func (provider *appleProvider) GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error) {
+	raw, ok := token.Extra("id_token").(string)
+
+	if !ok || raw == "" {
+		return nil, fmt.Errorf("id token is missing in token response")
+	}
+
+	var claims AppleClaims
+	err := provider.verifier.Verify(ctx, raw, request.Nonce, &claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// user json is not signed, so only name is taken from it, email always comes from id token
+	if request.User != "" {
+		var user appleUser
+
+		if err := json.Unmarshal([]byte(request.User), &user); err == nil {
+			claims.name = strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+		}
+	}
+
+	return NormalizeProfile(claims), nil
+}
+
+// VerifyIDToken verifies id token of apple sdk, name is not part of it
+// and is passed by the app on first authorization
+func (provider *appleProvider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*ProfileImpl, error) {
+	var claims AppleClaims
+	err := provider.nativeVerifier.Verify(ctx, raw, nonce, &claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NormalizeProfile(claims), nil
+}
@@ -0,0 +1,95 @@
+package ouathservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"oauth-go/internal/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	Register("gitea", newGiteaProvider)
+	// forgejo is a gitea fork with the same oauth and api endpoints
+	Register("forgejo", newGiteaProvider)
+}
+
+type GiteaProfile struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	FullName  string `json:"full_name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+func (g GiteaProfile) GetID() string {
+	return strconv.Itoa(g.ID)
+}
+
+func (g GiteaProfile) GetEmail() string {
+	return g.Email
+}
+
+// IsEmailVerified is always false, verified emails are resolved with /user/emails
+func (g GiteaProfile) IsEmailVerified() bool {
+	return false
+}
+
+func (g GiteaProfile) GetName() string {
+	if g.FullName != "" {
+		return g.FullName
+	}
+
+	return g.Login
+}
+
+func (g GiteaProfile) GetAvatarURL() string {
+	return g.AvatarURL
+}
+
+type giteaProvider struct {
+	baseURL string
+}
+
+// newGiteaProvider requires <NAME>_BASE_URL of gitea or forgejo instance, e.g. https://codeberg.org
+func newGiteaProvider(config *types.ProviderConfig) (Provider, error) {
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+
+	if baseURL == "" {
+		return nil, fmt.Errorf("base url is required")
+	}
+
+	return &giteaProvider{
+		baseURL: baseURL,
+	}, nil
+}
+
+func (provider *giteaProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	return oauth2.Endpoint{
+		AuthURL:  provider.baseURL + "/login/oauth/authorize",
+		TokenURL: provider.baseURL + "/login/oauth/access_token",
+	}, nil
+}
+
+func (provider *giteaProvider) Scopes() []string {
+	return []string{"read:user"}
+}
+
+func (provider *giteaProvider) GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error) {
+	profile, err := fetchProfile[GiteaProfile](ctx, client, provider.baseURL+"/api/v1/user")
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = fetchVerifiedEmails(ctx, client, provider.baseURL+"/api/v1/user/emails", profile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,296 @@
+package ouathservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/configurator"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const (
+	githubURL    = "https://github.com"
+	githubAPIURL = "https://api.github.com"
+)
+
+func init() {
+	Register("github", newGithubProvider)
+}
+
+type GithubProfile struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+func (g GithubProfile) GetID() string {
+	return strconv.Itoa(g.ID)
+}
+
+func (g GithubProfile) GetEmail() string {
+	return g.Email
+}
+
+// IsEmailVerified is always false, public profile email is not guaranteed
+// to be verified, verified emails are resolved with /user/emails
+func (g GithubProfile) IsEmailVerified() bool {
+	return false
+}
+
+func (g GithubProfile) GetName() string {
+	return g.Name
+}
+
+func (g GithubProfile) GetAvatarURL() string {
+	return g.AvatarURL
+}
+
+// githubEmail is an item of /user/emails, gitea serves the same format
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// fetchVerifiedEmails sets profile email to primary verified address of emails url
+// and collects all verified addresses
+func fetchVerifiedEmails(ctx context.Context, client *http.Client, url string, profile *ProfileImpl) error {
+	var emails []githubEmail
+	err := getJSON(ctx, client, url, &emails)
+
+	if err != nil {
+		return fmt.Errorf("cannot get emails: %w", err)
+	}
+
+	primary := ""
+
+	for _, email := range emails {
+		if !email.Verified {
+			continue
+		}
+
+		profile.VerifiedEmails = append(profile.VerifiedEmails, email.Email)
+
+		// other verified addresses are not used when primary one is unverified
+		if email.Primary {
+			primary = email.Email
+		}
+	}
+
+	if primary == "" {
+		return ErrNoVerifiedEmail
+	}
+
+	profile.Email = primary
+	profile.EmailVerified = true
+
+	return nil
+}
+
+type githubConfig struct {
+	// organizations which members can sign in
+	AllowedOrgs []string `env:"ALLOWED_ORGS" env_optional:"true"`
+	// teams as org/team-slug which members can sign in
+	AllowedTeams []string `env:"ALLOWED_TEAMS" env_optional:"true"`
+	// fetch organizations and teams for claims mapping rules
+	FetchMemberships bool `env:"FETCH_MEMBERSHIPS" env_optional:"true"`
+}
+
+type githubOrganization struct {
+	Login string `json:"login"`
+}
+
+type githubTeam struct {
+	Slug         string             `json:"slug"`
+	Organization githubOrganization `json:"organization"`
+}
+
+type githubProvider struct {
+	endpoint           oauth2.Endpoint
+	apiURL             string
+	allowedOrgs        []string
+	allowedTeams       []string
+	includeMemberships bool
+}
+
+// newGithubProvider uses github.com by default,
+// GITHUB_BASE_URL points it to a GitHub Enterprise Server instance
+func newGithubProvider(config *types.ProviderConfig) (Provider, error) {
+	var githubConfig githubConfig
+	err := configurator.Parse(&githubConfig, config.EnvPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &githubProvider{
+		endpoint:           github.Endpoint,
+		apiURL:             githubAPIURL,
+		allowedOrgs:        githubConfig.AllowedOrgs,
+		allowedTeams:       githubConfig.AllowedTeams,
+		includeMemberships: githubConfig.FetchMemberships,
+	}
+
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+
+	if baseURL != "" && baseURL != githubURL {
+		provider.endpoint = oauth2.Endpoint{
+			AuthURL:  baseURL + "/login/oauth/authorize",
+			TokenURL: baseURL + "/login/oauth/access_token",
+		}
+		provider.apiURL = baseURL + "/api/v3"
+	}
+
+	return provider, nil
+}
+
+// needsMemberships reports whether organizations and teams have to be fetched on sign-in
+func (provider *githubProvider) needsMemberships() bool {
+	return provider.includeMemberships || provider.isRestricted()
+}
+
+// isRestricted reports whether sign-in is limited to organization or team members
+func (provider *githubProvider) isRestricted() bool {
+	return len(provider.allowedOrgs) > 0 || len(provider.allowedTeams) > 0
+}
+
+func (provider *githubProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	return provider.endpoint, nil
+}
+
+func (provider *githubProvider) Scopes() []string {
+	if provider.needsMemberships() {
+		// private memberships are visible only with read:org
+		return []string{"read:user", "user:email", "read:org"}
+	}
+
+	return []string{"read:user", "user:email"}
+}
+
+// githubMaxPages limits pages of list requests, pages have 100 items
+const githubMaxPages = 20
+
+// githubNextPage returns url of rel="next" from Link header, empty on the last page
+func githubNextPage(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, found := strings.Cut(link, ";")
+
+		if !found || !slices.Contains(strings.Fields(strings.ReplaceAll(params, ";", " ")), `rel="next"`) {
+			continue
+		}
+
+		return strings.Trim(strings.TrimSpace(target), "<>")
+	}
+
+	return ""
+}
+
+// getGithubList requests all pages of github list endpoint following Link header,
+// next pages are requested only from github api, the client carries user token
+func getGithubList[T any](ctx context.Context, client *http.Client, apiURL string, path string) ([]T, error) {
+	var items []T
+
+	url := apiURL + path
+
+	for page := 0; url != ""; page++ {
+		if page == githubMaxPages {
+			return nil, fmt.Errorf("more than %d pages of %s", githubMaxPages, path)
+		}
+
+		if !strings.HasPrefix(url, apiURL+"/") {
+			return nil, fmt.Errorf("next page %s is not on %s", url, apiURL)
+		}
+
+		var pageItems []T
+		header, err := getJSONWithHeader(ctx, client, url, &pageItems)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, pageItems...)
+		url = githubNextPage(header)
+	}
+
+	return items, nil
+}
+
+// fetchMemberships sets organizations and teams of user to profile
+func (provider *githubProvider) fetchMemberships(ctx context.Context, client *http.Client, profile *ProfileImpl) error {
+	organizations, err := getGithubList[githubOrganization](ctx, client, provider.apiURL, "/user/orgs?per_page=100")
+
+	if err != nil {
+		return fmt.Errorf("cannot get organizations: %w", err)
+	}
+
+	for _, organization := range organizations {
+		profile.Organizations = append(profile.Organizations, organization.Login)
+	}
+
+	teams, err := getGithubList[githubTeam](ctx, client, provider.apiURL, "/user/teams?per_page=100")
+
+	if err != nil {
+		return fmt.Errorf("cannot get teams: %w", err)
+	}
+
+	for _, team := range teams {
+		profile.Teams = append(profile.Teams, team.Organization.Login+"/"+team.Slug)
+	}
+
+	return nil
+}
+
+// Admit allows members of any of GITHUB_ALLOWED_ORGS or GITHUB_ALLOWED_TEAMS
+func (provider *githubProvider) Admit(profile *ProfileImpl) error {
+	if !provider.isRestricted() {
+		return nil
+	}
+
+	isMember := slices.ContainsFunc(profile.Organizations, func(organization string) bool {
+		return containsFold(provider.allowedOrgs, organization)
+	})
+
+	isTeamMember := slices.ContainsFunc(profile.Teams, func(team string) bool {
+		return containsFold(provider.allowedTeams, team)
+	})
+
+	if !isMember && !isTeamMember {
+		return fmt.Errorf("%w: user is not a member of allowed organizations or teams", ErrAccessDenied)
+	}
+
+	return nil
+}
+
+func (provider *githubProvider) GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error) {
+	profile, err := fetchProfile[GithubProfile](ctx, client, provider.apiURL+"/user")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// profile email is empty for private emails and may be unverified,
+	// so primary verified email is taken from the emails list
+	err = fetchVerifiedEmails(ctx, client, provider.apiURL+"/user/emails", profile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.needsMemberships() {
+		err = provider.fetchMemberships(ctx, client, profile)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return profile, nil
+}
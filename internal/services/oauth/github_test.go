@@ -0,0 +1,70 @@
+package ouathservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestFetchVerifiedEmails(t *testing.T) {
+	tests := []struct {
+		name     string
+		emails   []githubEmail
+		email    string
+		verified []string
+		err      error
+	}{
+		{
+			name: "primary verified",
+			emails: []githubEmail{
+				{Email: "work@example.com", Verified: true},
+				{Email: "alice@example.com", Primary: true, Verified: true},
+			},
+			email:    "alice@example.com",
+			verified: []string{"work@example.com", "alice@example.com"},
+		},
+		{
+			name: "primary unverified",
+			emails: []githubEmail{
+				{Email: "alice@example.com", Primary: true},
+				{Email: "work@example.com", Verified: true},
+			},
+			err: ErrNoVerifiedEmail,
+		},
+		{
+			name:   "no emails",
+			emails: []githubEmail{},
+			err:    ErrNoVerifiedEmail,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(test.emails)
+			}))
+
+			defer server.Close()
+
+			profile := &ProfileImpl{}
+			err := fetchVerifiedEmails(context.Background(), server.Client(), server.URL, profile)
+
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if test.err != nil {
+				return
+			}
+
+			if profile.Email != test.email || !profile.EmailVerified || !slices.Equal(profile.VerifiedEmails, test.verified) {
+				t.Fatalf("unexpected profile %+v", profile)
+			}
+		})
+	}
+}
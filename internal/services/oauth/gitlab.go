@@ -0,0 +1,84 @@
+package ouathservice
+
+import (
+	"context"
+	"net/http"
+	"oauth-go/internal/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const gitlabURL = "https://gitlab.com"
+
+func init() {
+	Register("gitlab", newGitlabProvider)
+}
+
+type GitlabProfile struct {
+	ID          int     `json:"id"`
+	Username    string  `json:"username"`
+	Name        string  `json:"name"`
+	Email       string  `json:"email"`
+	AvatarURL   string  `json:"avatar_url"`
+	ConfirmedAt *string `json:"confirmed_at"`
+}
+
+func (g GitlabProfile) GetID() string {
+	return strconv.Itoa(g.ID)
+}
+
+func (g GitlabProfile) GetEmail() string {
+	return g.Email
+}
+
+// IsEmailVerified reports whether user confirmed primary email,
+// instances with disabled confirmation mark every user as confirmed
+func (g GitlabProfile) IsEmailVerified() bool {
+	return g.ConfirmedAt != nil
+}
+
+func (g GitlabProfile) GetName() string {
+	if g.Name != "" {
+		return g.Name
+	}
+
+	return g.Username
+}
+
+func (g GitlabProfile) GetAvatarURL() string {
+	return g.AvatarURL
+}
+
+type gitlabProvider struct {
+	baseURL string
+}
+
+// newGitlabProvider uses gitlab.com by default, GITLAB_BASE_URL points it to a self-hosted instance
+func newGitlabProvider(config *types.ProviderConfig) (Provider, error) {
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+
+	if baseURL == "" {
+		baseURL = gitlabURL
+	}
+
+	return &gitlabProvider{
+		baseURL: baseURL,
+	}, nil
+}
+
+func (provider *gitlabProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	return oauth2.Endpoint{
+		AuthURL:  provider.baseURL + "/oauth/authorize",
+		TokenURL: provider.baseURL + "/oauth/token",
+	}, nil
+}
+
+func (provider *gitlabProvider) Scopes() []string {
+	return []string{"read_user"}
+}
+
+func (provider *gitlabProvider) GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error) {
+	return fetchProfile[GitlabProfile](ctx, client, provider.baseURL+"/api/v4/user")
+}
@@ -0,0 +1,233 @@
+package ouathservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/configurator"
+	"slices"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	googleJWKSURL     = "https://www.googleapis.com/oauth2/v3/certs"
+	googleProfileURL  = "https://www.googleapis.com/oauth2/v2/userinfo"
+	googleGroupsURL   = "https://cloudidentity.googleapis.com/v1/groups/-/memberships:searchDirectGroups"
+	googleGroupsScope = "https://www.googleapis.com/auth/cloud-identity.groups.readonly"
+	// groups pages fetched on sign-in, 100 groups each
+	googleGroupsMaxPages = 10
+)
+
+func init() {
+	Register("google", newGoogleProvider)
+}
+
+type GoogleProfile struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	VerifiedEmail bool   `json:"verified_email"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Picture       string `json:"picture"`
+	Locale        string `json:"locale"`
+	// google workspace domain, empty for consumer accounts
+	HostedDomain string `json:"hd"`
+}
+
+func (g GoogleProfile) GetID() string {
+	return g.ID
+}
+
+func (g GoogleProfile) GetEmail() string {
+	return g.Email
+}
+
+func (g GoogleProfile) IsEmailVerified() bool {
+	return g.VerifiedEmail
+}
+
+func (g GoogleProfile) GetName() string {
+	return g.Name
+}
+
+func (g GoogleProfile) GetAvatarURL() string {
+	return g.Picture
+}
+
+type googleConfig struct {
+	// google workspace domains (hd claim) which users can sign in
+	AllowedDomains []string `env:"ALLOWED_DOMAINS" env_optional:"true"`
+	// fetch workspace groups of user for claims mapping rules
+	FetchGroups bool `env:"FETCH_GROUPS" env_optional:"true"`
+}
+
+type googleGroupMemberships struct {
+	Memberships []struct {
+		GroupKey struct {
+			ID string `json:"id"`
+		} `json:"groupKey"`
+	} `json:"memberships"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// GoogleIDTokenClaims are google id token claims
+type GoogleIDTokenClaims struct {
+	IDTokenClaims
+	// google workspace domain, empty for consumer accounts
+	HostedDomain string `json:"hd"`
+}
+
+// google issues id tokens with both issuer forms
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+type googleProvider struct {
+	allowedDomains []string
+	fetchGroups    bool
+	// verifies id tokens of native apps, issuer is checked in VerifyIDToken
+	nativeVerifier *idTokenVerifier
+}
+
+func newGoogleProvider(config *types.ProviderConfig) (Provider, error) {
+	var googleConfig googleConfig
+	err := configurator.Parse(&googleConfig, config.EnvPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &googleProvider{
+		allowedDomains: googleConfig.AllowedDomains,
+		fetchGroups:    googleConfig.FetchGroups,
+		nativeVerifier: newIDTokenVerifier("", googleJWKSURL, config.ClientID).native(config.NativeClientIDs),
+	}, nil
+}
+
+func (provider *googleProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	return google.Endpoint, nil
+}
+
+func (provider *googleProvider) Scopes() []string {
+	if provider.fetchGroups {
+		return []string{"openid", "email", "profile", googleGroupsScope}
+	}
+
+	return []string{"openid", "email", "profile"}
+}
+
+// celQuote quotes value as single quoted CEL string literal of groups api query,
+// emails may contain apostrophes, e.g. o'brien@example.com
+func celQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// fetchGroupEmails returns emails of groups which user is a direct member of
+func (provider *googleProvider) fetchGroupEmails(ctx context.Context, client *http.Client, email string) ([]string, error) {
+	groups := []string{}
+	pageToken := ""
+
+	for range googleGroupsMaxPages {
+		query := url.Values{}
+		query.Set("query", "member_key_id == "+celQuote(email))
+		query.Set("pageSize", "100")
+
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var page googleGroupMemberships
+		err := getJSON(ctx, client, googleGroupsURL+"?"+query.Encode(), &page)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot get google groups: %w", err)
+		}
+
+		for _, membership := range page.Memberships {
+			groups = append(groups, membership.GroupKey.ID)
+		}
+
+		pageToken = page.NextPageToken
+
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return groups, nil
+}
+
+// AuthURLOptions keeps previously granted scopes when extra scopes are requested,
+// and preselects workspace account on google account chooser when sign-in
+// is restricted to a single domain
+func (provider *googleProvider) AuthURLOptions() []oauth2.AuthCodeOption {
+	options := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("include_granted_scopes", "true"),
+	}
+
+	if len(provider.allowedDomains) == 1 {
+		options = append(options, oauth2.SetAuthURLParam("hd", provider.allowedDomains[0]))
+	}
+
+	return options
+}
+
+// Admit allows accounts of GOOGLE_ALLOWED_DOMAINS workspaces,
+// hd param of sign-in url is only a hint, so domain is checked on callback
+func (provider *googleProvider) Admit(profile *ProfileImpl) error {
+	if len(provider.allowedDomains) == 0 {
+		return nil
+	}
+
+	if profile.HostedDomain == "" || !containsFold(provider.allowedDomains, profile.HostedDomain) {
+		return fmt.Errorf("%w: hosted domain %q is not allowed", ErrAccessDenied, profile.HostedDomain)
+	}
+
+	return nil
+}
+
+// VerifyIDToken verifies id token of google sign-in sdk
+func (provider *googleProvider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*ProfileImpl, error) {
+	var claims GoogleIDTokenClaims
+	err := provider.nativeVerifier.Verify(ctx, raw, nonce, &claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(googleIssuers, claims.Issuer) {
+		return nil, fmt.Errorf("id token issuer %s is not google", claims.Issuer)
+	}
+
+	profile := NormalizeProfile(claims)
+	profile.HostedDomain = claims.HostedDomain
+
+	return profile, nil
+}
+
+func (provider *googleProvider) GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error) {
+	var info GoogleProfile
+	err := getJSON(ctx, client, googleProfileURL, &info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	profile := NormalizeProfile(info)
+	profile.HostedDomain = info.HostedDomain
+
+	// groups exist only for workspace accounts
+	if provider.fetchGroups && profile.HostedDomain != "" {
+		profile.Groups, err = provider.fetchGroupEmails(ctx, client, profile.Email)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,190 @@
+package ouathservice
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	jwksCacheTTL        = time.Hour
+	jwksMinRefreshDelay = time.Minute
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// keySet fetches provider signing keys and caches them,
+// keys are refetched after jwksCacheTTL or when unknown kid is requested
+type keySet struct {
+	url string
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(url string) *keySet {
+	return &keySet{
+		url:  url,
+		keys: map[string]crypto.PublicKey{},
+	}
+}
+
+func (set *keySet) GetKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	expired := time.Since(set.fetchedAt) > jwksCacheTTL
+
+	if key, ok := set.lookup(kid); ok && !expired {
+		return key, nil
+	}
+
+	// unknown kid may mean that provider rotated keys,
+	// refetch but do not allow to hammer provider with random kids
+	if expired || time.Since(set.fetchedAt) > jwksMinRefreshDelay {
+		err := set.refresh(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, ok := set.lookup(kid)
+
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", kid)
+	}
+
+	return key, nil
+}
+
+func (set *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(set.keys) == 1 {
+		for _, key := range set.keys {
+			return key, true
+		}
+	}
+
+	key, ok := set.keys[kid]
+
+	return key, ok
+}
+
+func (set *keySet) refresh(ctx context.Context) error {
+	var jwks jsonWebKeySet
+	err := getJSON(ctx, upstreamClient(ctx), set.url, &jwks)
+
+	if err != nil {
+		return fmt.Errorf("cannot fetch jwks: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+
+		if err != nil {
+			// skip keys of unsupported types, other keys are still usable
+			continue
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	set.keys = keys
+	set.fetchedAt = time.Now()
+
+	return nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
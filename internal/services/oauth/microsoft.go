@@ -0,0 +1,172 @@
+package ouathservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"oauth-go/internal/types"
+	"oauth-go/pkg/configurator"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	microsoftLoginURL = "https://login.microsoftonline.com"
+	// tenant of personal Microsoft accounts
+	microsoftConsumersTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+)
+
+// ErrTenantNotAllowed is returned when user directory is not in MICROSOFT_ALLOWED_TENANTS
+var ErrTenantNotAllowed = errors.New("tenant is not allowed")
+
+func init() {
+	Register("microsoft", newMicrosoftProvider)
+}
+
+type microsoftConfig struct {
+	// common, organizations, consumers or directory (tenant) id
+	Tenant string `env:"TENANT" env_default:"common"`
+	// tenant ids (tid claim) allowed to sign in, any tenant when empty
+	AllowedTenants []string `env:"ALLOWED_TENANTS" env_optional:"true"`
+}
+
+// MicrosoftClaims are Microsoft identity platform v2.0 id token claims
+type MicrosoftClaims struct {
+	ObjectID          string `json:"oid"`
+	TenantID          string `json:"tid"`
+	Email             string `json:"email"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Nonce             string `json:"nonce"`
+	// email domain is owned by tenant, without it email claim is not verified
+	EmailDomainOwnerVerified bool `json:"xms_edov"`
+	jwt.RegisteredClaims
+}
+
+func (c MicrosoftClaims) GetID() string {
+	if c.ObjectID != "" {
+		return c.ObjectID
+	}
+
+	return c.Subject
+}
+
+func (c MicrosoftClaims) GetEmail() string {
+	if c.Email != "" {
+		return c.Email
+	}
+
+	return c.PreferredUsername
+}
+
+func (c MicrosoftClaims) IsEmailVerified() bool {
+	return c.Email != "" && c.EmailDomainOwnerVerified
+}
+
+func (c MicrosoftClaims) GetName() string {
+	return c.Name
+}
+
+func (c MicrosoftClaims) GetAvatarURL() string {
+	return ""
+}
+
+func (c MicrosoftClaims) GetNonce() string {
+	return c.Nonce
+}
+
+type microsoftProvider struct {
+	tenant         string
+	allowedTenants []string
+	verifier       *idTokenVerifier
+}
+
+func newMicrosoftProvider(config *types.ProviderConfig) (Provider, error) {
+	var microsoft microsoftConfig
+	err := configurator.Parse(&microsoft, config.EnvPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	jwksURL := fmt.Sprintf("%s/%s/discovery/v2.0/keys", microsoftLoginURL, microsoft.Tenant)
+
+	return &microsoftProvider{
+		tenant:         microsoft.Tenant,
+		allowedTenants: microsoft.AllowedTenants,
+		// issuer depends on user tenant and is checked in checkTenant
+		verifier: newIDTokenVerifier("", jwksURL, config.ClientID),
+	}, nil
+}
+
+func (provider *microsoftProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	return oauth2.Endpoint{
+		AuthURL:  fmt.Sprintf("%s/%s/oauth2/v2.0/authorize", microsoftLoginURL, provider.tenant),
+		TokenURL: fmt.Sprintf("%s/%s/oauth2/v2.0/token", microsoftLoginURL, provider.tenant),
+	}, nil
+}
+
+func (provider *microsoftProvider) Scopes() []string {
+	return []string{"openid", "email", "profile", "offline_access"}
+}
+
+// checkTenant validates issuer of user tenant against configured endpoint and allowlist
+func (provider *microsoftProvider) checkTenant(claims *MicrosoftClaims) error {
+	if claims.TenantID == "" {
+		return fmt.Errorf("id token has no tenant")
+	}
+
+	issuer := fmt.Sprintf("%s/%s/v2.0", microsoftLoginURL, claims.TenantID)
+
+	if claims.Issuer != issuer {
+		return fmt.Errorf("id token issuer %s does not match tenant %s", claims.Issuer, claims.TenantID)
+	}
+
+	switch provider.tenant {
+	case "common":
+	case "organizations":
+		if claims.TenantID == microsoftConsumersTenantID {
+			return fmt.Errorf("%w: personal accounts are not allowed", ErrTenantNotAllowed)
+		}
+	case "consumers":
+		if claims.TenantID != microsoftConsumersTenantID {
+			return fmt.Errorf("%w: only personal accounts are allowed", ErrTenantNotAllowed)
+		}
+	default:
+		if claims.TenantID != provider.tenant {
+			return fmt.Errorf("%w: %s", ErrTenantNotAllowed, claims.TenantID)
+		}
+	}
+
+	if len(provider.allowedTenants) > 0 && !slices.Contains(provider.allowedTenants, claims.TenantID) {
+		return fmt.Errorf("%w: %s", ErrTenantNotAllowed, claims.TenantID)
+	}
+
+	return nil
+}
+
+func (provider *microsoftProvider) GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error) {
+	raw, ok := token.Extra("id_token").(string)
+
+	if !ok || raw == "" {
+		return nil, fmt.Errorf("id token is missing in token response")
+	}
+
+	var claims MicrosoftClaims
+	err := provider.verifier.Verify(ctx, raw, request.Nonce, &claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = provider.checkTenant(&claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NormalizeProfile(claims), nil
+}
@@ -2,101 +2,198 @@ package ouathservice
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"log/slog"
+	"maps"
+	"net/url"
 	"oauth-go/internal/types"
+	"oauth-go/pkg/configurator"
 	"slices"
+	"sort"
+	"strings"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
-	"golang.org/x/oauth2/google"
 )
 
-var SupportedProviders = []string{"google", "github"}
-var ProfileURLs = map[string]string{
-	"github": "https://api.github.com/user",
-	"google": "https://www.googleapis.com/oauth2/v2/userinfo",
-}
+// DefaultRedirect is name of <NAME>_REDIRECT_URL
+const DefaultRedirect = "default"
 
 type OAuthService interface {
 	IsSupported(provider string) error
-	GetSignInUrl(provider string) (string, error)
-	GetProfile(ctx context.Context, provider string, code string)
+	ValidateScopes(provider string, scopes []string) error
+	GrantedScopes(provider string, token *oauth2.Token, request *AuthRequest) []string
+	EnabledProviders() []string
+	GetSignInUrl(ctx context.Context, provider string, request *AuthRequest) (string, error)
+	GetProfile(ctx context.Context, provider string, code string, request *AuthRequest) (*ProfileImpl, *oauth2.Token, error)
+	VerifyIDToken(ctx context.Context, provider string, raw string, nonce string) (*ProfileImpl, error)
+	RefreshToken(ctx context.Context, provider string, token *oauth2.Token) (*oauth2.Token, error)
+	UpstreamStats() []UpstreamStats
+	RedirectURL(provider string, name string) (string, error)
 }
 
 type OAuth struct {
-	config *types.AppConfig
+	config    *types.AppConfig
+	providers map[string]Provider
+	configs   map[string]*types.ProviderConfig
+	// registered redirect urls of provider by name, REDIRECT_URL is named default
+	redirects map[string]map[string]string
+	// http clients with retries and circuit breaker, one per provider
+	upstreams map[string]*upstream
 }
 
-func New(config *types.AppConfig) *OAuth {
-	return &OAuth{
-		config: config,
+// New creates providers listed in OAUTH_PROVIDERS,
+// providers without credentials are skipped
+func New(config *types.AppConfig, logger *slog.Logger) *OAuth {
+	oauth := &OAuth{
+		config:    config,
+		providers: map[string]Provider{},
+		configs:   map[string]*types.ProviderConfig{},
+		redirects: map[string]map[string]string{},
+		upstreams: map[string]*upstream{},
 	}
-}
 
-func getProfile[T Profile](provider string, client *http.Client) (*ProfileImpl, error) {
-	url, ok := ProfileURLs[provider]
+	for _, name := range config.OAuthProviders {
+		providerConfig := types.ProviderConfig{EnvPrefix: envPrefix(name)}
+		err := configurator.Parse(&providerConfig, providerConfig.EnvPrefix)
 
-	if !ok {
-		return nil, fmt.Errorf("no url for selected provider %s", url)
+		if err != nil {
+			logger.Warn("oauth provider is not configured, skipping", "provider", name, "error", err)
+			continue
+		}
+
+		if providerConfig.Type == "" {
+			providerConfig.Type = name
+		}
+
+		factory, ok := registry[providerConfig.Type]
+
+		if !ok {
+			logger.Warn("unknown oauth provider type, skipping", "provider", name, "type", providerConfig.Type, "registered", RegisteredProviders())
+			continue
+		}
+
+		provider, err := factory(&providerConfig)
+
+		if err != nil {
+			logger.Warn("cannot create oauth provider, skipping", "provider", name, "error", err)
+			continue
+		}
+
+		if _, ok := provider.(ClientSecretSource); !ok && providerConfig.ClientSecret == "" {
+			logger.Warn("oauth provider is not configured, skipping", "provider", name, "error", envPrefix(name)+"CLIENT_SECRET is required")
+			continue
+		}
+
+		redirects, err := parseRedirectURLs(&providerConfig)
+
+		if err != nil {
+			logger.Warn("invalid redirect urls, skipping", "provider", name, "error", err)
+			continue
+		}
+
+		oauth.providers[name] = provider
+		oauth.redirects[name] = redirects
+		oauth.configs[name] = &providerConfig
+		oauth.upstreams[name] = newUpstream(name, config)
 	}
 
-	response, err := client.Get(url)
-	if err != nil {
-		return nil, err
+	logger.Info("oauth providers enabled", "providers", oauth.EnabledProviders())
+
+	return oauth
+}
+
+// envPrefix returns env variables prefix for provider, e.g. GOOGLE_
+func envPrefix(provider string) string {
+	return strings.ToUpper(strings.ReplaceAll(provider, "-", "_")) + "_"
+}
+
+// parseRedirectURLs returns REDIRECT_URL and REDIRECT_URLS of provider by name
+func parseRedirectURLs(config *types.ProviderConfig) (map[string]string, error) {
+	redirects := map[string]string{DefaultRedirect: config.RedirectURL}
+
+	for _, item := range config.RedirectURLs {
+		name, value, ok := strings.Cut(item, "=")
+
+		if !ok || name == "" {
+			return nil, fmt.Errorf("redirect url %s is not a name=url pair", item)
+		}
+
+		if _, ok := redirects[name]; ok {
+			return nil, fmt.Errorf("redirect url %s is registered twice", name)
+		}
+
+		redirects[name] = value
 	}
 
-	body, err := io.ReadAll(response.Body)
+	for name, value := range redirects {
+		redirectURL, err := url.Parse(value)
 
-	if err != nil {
-		return nil, err
+		if err != nil || !redirectURL.IsAbs() || redirectURL.Host == "" || redirectURL.Fragment != "" {
+			return nil, fmt.Errorf("redirect url %s must be absolute url without fragment", name)
+		}
 	}
 
-	var info T
-	err = json.Unmarshal(body, &info)
+	return redirects, nil
+}
 
-	if err != nil {
-		return nil, err
+// requestedScopes returns provider default scopes merged with extra scopes of request
+func (oauth *OAuth) requestedScopes(provider string, request *AuthRequest) []string {
+	scopes := slices.Clone(oauth.providers[provider].Scopes())
+
+	if request == nil {
+		return scopes
 	}
 
-	return &ProfileImpl{
-		ID:        info.GetID(),
-		Email:     info.GetEmail(),
-		Name:      info.GetName(),
-		AvatarURL: info.GetAvatarURL(),
-	}, nil
+	for _, scope := range request.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
 }
 
-func (oauth *OAuth) getConfig(provider string) (*oauth2.Config, error) {
+func (oauth *OAuth) getConfig(ctx context.Context, provider string, request *AuthRequest) (*oauth2.Config, error) {
 	err := oauth.IsSupported(provider)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if provider == "google" {
-		return &oauth2.Config{
-			Scopes:       []string{"openid", "email", "profile"},
-			Endpoint:     google.Endpoint,
-			ClientID:     oauth.config.GoogleClientId,
-			ClientSecret: oauth.config.GoogleClientSecret,
-			RedirectURL:  oauth.config.GoogleRedirectURL,
-		}, nil
+	endpoint, err := oauth.providers[provider].Endpoint(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot get provider endpoint %w", err)
 	}
 
-	if provider == "github" {
-		return &oauth2.Config{
-			Scopes:       []string{"read:user", "user:email"},
-			Endpoint:     github.Endpoint,
-			ClientID:     oauth.config.GithubClientId,
-			ClientSecret: oauth.config.GithubClientSecret,
-			RedirectURL:  oauth.config.GithubRedirectURL,
-		}, nil
+	providerConfig := oauth.configs[provider]
+	clientSecret := providerConfig.ClientSecret
+	redirectURL := providerConfig.RedirectURL
+
+	if request != nil && request.RedirectURL != "" {
+		// state is saved before config can change, so url is checked again
+		if !slices.Contains(slices.Collect(maps.Values(oauth.redirects[provider])), request.RedirectURL) {
+			return nil, fmt.Errorf("redirect url %s is not registered", request.RedirectURL)
+		}
+
+		redirectURL = request.RedirectURL
+	}
+
+	if source, ok := oauth.providers[provider].(ClientSecretSource); ok {
+		clientSecret, err = source.ClientSecret()
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate client secret %w", err)
+		}
 	}
 
-	return nil, fmt.Errorf("unsuported provider")
+	return &oauth2.Config{
+		Scopes:       oauth.requestedScopes(provider, request),
+		Endpoint:     endpoint,
+		ClientID:     providerConfig.ClientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	}, nil
 }
 
 func (oauth *OAuth) IsSupported(provider string) error {
@@ -104,73 +201,225 @@ func (oauth *OAuth) IsSupported(provider string) error {
 		return fmt.Errorf("provider is required")
 	}
 
-	if !slices.Contains(SupportedProviders, provider) {
+	if _, ok := oauth.providers[provider]; !ok {
 		return fmt.Errorf("unsupported oauth provider")
 	}
 
 	return nil
 }
 
-func (oauth *OAuth) GetSignInUrl(provider string, state string) (string, error) {
+// RedirectURL returns registered redirect url of provider by name,
+// only registered urls can be selected, so callback cannot be sent to arbitrary host
+func (oauth *OAuth) RedirectURL(provider string, name string) (string, error) {
 	err := oauth.IsSupported(provider)
 
 	if err != nil {
 		return "", err
 	}
 
-	oAuthConfig, err := oauth.getConfig(provider)
+	if name == "" {
+		name = DefaultRedirect
+	}
+
+	redirectURL, ok := oauth.redirects[provider][name]
+
+	if !ok {
+		return "", fmt.Errorf("redirect %s is not registered for %s", name, provider)
+	}
+
+	return redirectURL, nil
+}
+
+// ValidateScopes checks that every scope is a default scope of provider
+// or is allowlisted with <NAME>_ALLOWED_SCOPES
+func (oauth *OAuth) ValidateScopes(provider string, scopes []string) error {
+	err := oauth.IsSupported(provider)
+
+	if err != nil {
+		return err
+	}
+
+	defaults := oauth.providers[provider].Scopes()
+	allowed := oauth.configs[provider].AllowedScopes
+
+	for _, scope := range scopes {
+		if !slices.Contains(defaults, scope) && !slices.Contains(allowed, scope) {
+			return fmt.Errorf("scope %s is not allowed for %s", scope, provider)
+		}
+	}
+
+	return nil
+}
+
+// GrantedScopes returns scopes reported in token response,
+// providers which do not report them granted everything requested
+func (oauth *OAuth) GrantedScopes(provider string, token *oauth2.Token, request *AuthRequest) []string {
+	granted, _ := token.Extra("scope").(string)
+
+	if granted == "" {
+		return oauth.requestedScopes(provider, request)
+	}
+
+	// github separates scopes with commas
+	return strings.FieldsFunc(granted, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+}
+
+func (oauth *OAuth) EnabledProviders() []string {
+	names := make([]string, 0, len(oauth.providers))
+
+	for name := range oauth.providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (oauth *OAuth) GetSignInUrl(ctx context.Context, provider string, request *AuthRequest) (string, error) {
+	ctx = oauth.withUpstream(ctx, provider)
+	oAuthConfig, err := oauth.getConfig(ctx, provider, request)
 
 	if err != nil {
 		return "", fmt.Errorf("cannot get oauth config %w", err)
 	}
 
-	url := oAuthConfig.AuthCodeURL(
-		state,
+	options := []oauth2.AuthCodeOption{
 		oauth2.AccessTypeOffline,
 		oauth2.SetAuthURLParam("prompt", "consent"),
-	)
+		oauth2.SetAuthURLParam("nonce", request.Nonce),
+		oauth2.S256ChallengeOption(request.CodeVerifier),
+	}
+
+	if optioner, ok := oauth.providers[provider].(AuthURLOptioner); ok {
+		options = append(options, optioner.AuthURLOptions()...)
+	}
+
+	return oAuthConfig.AuthCodeURL(request.State, options...), nil
+}
+
+// GetProfile exchanges code and returns user profile
+// together with provider tokens, so they can be stored for later api calls
+func (oauth *OAuth) GetProfile(ctx context.Context, provider string, code string, request *AuthRequest) (*ProfileImpl, *oauth2.Token, error) {
+	ctx = oauth.withUpstream(ctx, provider)
+	oAuthConfig, err := oauth.getConfig(ctx, provider, request)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get oauth config %w", err)
+	}
+
+	tokens, err := oAuthConfig.Exchange(ctx, code, oauth2.VerifierOption(request.CodeVerifier))
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot exchange tokens %w", unavailable(err))
+	}
+
+	client := oAuthConfig.Client(ctx, tokens)
+
+	profile, err := oauth.providers[provider].GetProfile(ctx, client, tokens, request)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = oauth.admit(provider, profile)
+
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return url, nil
+	return profile, tokens, nil
 }
 
-func (oauth *OAuth) GetProfile(ctx context.Context, provider string, code string) (*ProfileImpl, error) {
+// VerifyIDToken returns profile of id token issued to native app by provider sdk
+func (oauth *OAuth) VerifyIDToken(ctx context.Context, provider string, raw string, nonce string) (*ProfileImpl, error) {
 	err := oauth.IsSupported(provider)
 
 	if err != nil {
 		return nil, err
 	}
 
-	oAuthConfig, err := oauth.getConfig(provider)
+	idTokenProvider, ok := oauth.providers[provider].(IDTokenProvider)
+
+	if !ok {
+		return nil, ErrIDTokenNotSupported
+	}
+
+	profile, err := idTokenProvider.VerifyIDToken(oauth.withUpstream(ctx, provider), raw, nonce)
 
 	if err != nil {
-		return nil, fmt.Errorf("cannot get oauth config %w", err)
+		return nil, err
 	}
 
-	tokens, err := oAuthConfig.Exchange(ctx, code)
+	err = oauth.admit(provider, profile)
 
 	if err != nil {
-		return nil, fmt.Errorf("cannot exchange tokens %w", err)
+		return nil, err
 	}
 
-	client := oAuthConfig.Client(ctx, tokens)
+	return profile, nil
+}
 
-	if provider == "google" {
-		user, err := getProfile[GoogleProfile](provider, client)
-		if err != nil {
-			return nil, err
+// admit checks provider admission rules and <NAME>_ALLOWED_EMAIL_DOMAINS,
+// it runs before user is created or identity is linked
+func (oauth *OAuth) admit(provider string, profile *ProfileImpl) error {
+	if admitter, ok := oauth.providers[provider].(Admitter); ok {
+		if err := admitter.Admit(profile); err != nil {
+			return err
 		}
+	}
+
+	domains := oauth.configs[provider].AllowedEmailDomains
 
-		return user, nil
+	if len(domains) == 0 {
+		return nil
 	}
 
-	if provider == "github" {
-		user, err := getProfile[GithubProfile](provider, client)
-		if err != nil {
-			return nil, err
-		}
+	if !profile.EmailVerified {
+		return fmt.Errorf("%w: email is not verified", ErrAccessDenied)
+	}
+
+	domain := profile.Email[strings.LastIndex(profile.Email, "@")+1:]
 
-		return user, nil
+	if !containsFold(domains, domain) {
+		return fmt.Errorf("%w: email domain %s is not allowed", ErrAccessDenied, domain)
+	}
+
+	return nil
+}
+
+// containsFold reports whether list contains value ignoring case
+func containsFold(list []string, value string) bool {
+	return slices.ContainsFunc(list, func(item string) bool {
+		return strings.EqualFold(item, value)
+	})
+}
+
+// RefreshToken returns token as is while it is valid,
+// otherwise exchanges refresh token for a new one
+func (oauth *OAuth) RefreshToken(ctx context.Context, provider string, token *oauth2.Token) (*oauth2.Token, error) {
+	if token.Valid() {
+		return token, nil
+	}
+
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("token is expired and cannot be refreshed")
+	}
+
+	ctx = oauth.withUpstream(ctx, provider)
+	oAuthConfig, err := oauth.getConfig(ctx, provider, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot get oauth config %w", err)
+	}
+
+	refreshed, err := oAuthConfig.TokenSource(ctx, token).Token()
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot refresh token %w", unavailable(err))
 	}
 
-	return nil, fmt.Errorf("unsuported profile")
+	return refreshed, nil
 }
@@ -0,0 +1,276 @@
+package ouathservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"oauth-go/internal/types"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	Register("oidc", newOIDCProvider)
+}
+
+// signing algorithms accepted for id tokens, "none" and HMAC are never accepted
+var idTokenSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are standard OpenID Connect id token claims
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+	// authentication methods references (RFC 8176) of upstream sign-in
+	AMR []string `json:"amr"`
+	jwt.RegisteredClaims
+}
+
+func (c IDTokenClaims) GetID() string {
+	return c.Subject
+}
+
+func (c IDTokenClaims) GetEmail() string {
+	return c.Email
+}
+
+func (c IDTokenClaims) IsEmailVerified() bool {
+	return c.EmailVerified
+}
+
+func (c IDTokenClaims) GetName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	return c.PreferredUsername
+}
+
+func (c IDTokenClaims) GetAvatarURL() string {
+	return c.Picture
+}
+
+func (c IDTokenClaims) GetNonce() string {
+	return c.Nonce
+}
+
+func (c IDTokenClaims) GetAMR() []string {
+	return c.AMR
+}
+
+// noncedClaims are id token claims carrying sign-in nonce
+type noncedClaims interface {
+	GetNonce() string
+}
+
+// idTokenVerifier validates id token signature against issuer JWKS
+// and checks iss, aud, exp and nonce claims, empty issuer skips iss check
+// for multi-tenant providers which validate it themselves
+type idTokenVerifier struct {
+	issuer    string
+	audiences []string
+	keys      *keySet
+	// native sdks may put sha256 hex of the nonce into token, e.g. apple
+	hashedNonce bool
+}
+
+func newIDTokenVerifier(issuer string, jwksURL string, audiences ...string) *idTokenVerifier {
+	return &idTokenVerifier{
+		issuer:    issuer,
+		audiences: audiences,
+		keys:      newKeySet(jwksURL),
+	}
+}
+
+// native returns verifier of tokens issued to native apps by provider sdk,
+// it shares keys with web verifier and accepts native app client ids as audience
+func (verifier *idTokenVerifier) native(clientIDs []string) *idTokenVerifier {
+	return &idTokenVerifier{
+		issuer:      verifier.issuer,
+		audiences:   append(slices.Clone(verifier.audiences), clientIDs...),
+		keys:        verifier.keys,
+		hashedNonce: true,
+	}
+}
+
+// matchNonce compares token nonce with expected one in constant time
+func (verifier *idTokenVerifier) matchNonce(tokenNonce string, nonce string) bool {
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) == 1 {
+		return true
+	}
+
+	if !verifier.hashedNonce {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(nonce))
+
+	return subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(hex.EncodeToString(hash[:]))) == 1
+}
+
+// Verify parses raw id token into claims, claims must be a pointer to struct
+// embedding jwt.RegisteredClaims, e.g. *IDTokenClaims
+func (verifier *idTokenVerifier) Verify(ctx context.Context, raw string, nonce string, claims jwt.Claims) error {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(idTokenSigningMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	}
+
+	if verifier.issuer != "" {
+		options = append(options, jwt.WithIssuer(verifier.issuer))
+	}
+
+	parser := jwt.NewParser(options...)
+
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return verifier.keys.GetKey(ctx, kid)
+	})
+
+	if err != nil {
+		return fmt.Errorf("invalid id token: %w", err)
+	}
+
+	audience, err := claims.GetAudience()
+
+	if err != nil {
+		return fmt.Errorf("invalid id token audience: %w", err)
+	}
+
+	if !slices.ContainsFunc(audience, func(aud string) bool { return slices.Contains(verifier.audiences, aud) }) {
+		return fmt.Errorf("id token issued for another audience %v", audience)
+	}
+
+	if nonced, ok := claims.(noncedClaims); ok {
+		if !verifier.matchNonce(nonced.GetNonce(), nonce) {
+			return fmt.Errorf("id token nonce mismatch")
+		}
+	}
+
+	return nil
+}
+
+// discoverOIDC fetches issuer OpenID Connect configuration
+func discoverOIDC(ctx context.Context, issuer string) (*oidcDiscovery, error) {
+	var discovery oidcDiscovery
+	err := getJSON(ctx, upstreamClient(ctx), issuer+"/.well-known/openid-configuration", &discovery)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch openid configuration: %w", err)
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("openid configuration issuer %s does not match %s", discovery.Issuer, issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return nil, fmt.Errorf("openid configuration is incomplete")
+	}
+
+	return &discovery, nil
+}
+
+// oidcProvider is a generic OpenID Connect provider,
+// endpoints are discovered from ISSUER_URL on first use
+type oidcProvider struct {
+	issuer   string
+	clientID string
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	verifier  *idTokenVerifier
+}
+
+func newOIDCProvider(config *types.ProviderConfig) (Provider, error) {
+	if config.IssuerURL == "" {
+		return nil, fmt.Errorf("issuer url is required for oidc provider")
+	}
+
+	return &oidcProvider{
+		issuer:   strings.TrimSuffix(config.IssuerURL, "/"),
+		clientID: config.ClientID,
+	}, nil
+}
+
+// discover returns cached discovery document, failed discovery
+// is retried on next call so provider outage at startup is not fatal
+func (provider *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, *idTokenVerifier, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, provider.verifier, nil
+	}
+
+	discovery, err := discoverOIDC(ctx, provider.issuer)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider.discovery = discovery
+	provider.verifier = newIDTokenVerifier(discovery.Issuer, discovery.JwksURI, provider.clientID)
+
+	return provider.discovery, provider.verifier, nil
+}
+
+func (provider *oidcProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	discovery, _, err := provider.discover(ctx)
+
+	if err != nil {
+		return oauth2.Endpoint{}, err
+	}
+
+	return oauth2.Endpoint{
+		AuthURL:  discovery.AuthorizationEndpoint,
+		TokenURL: discovery.TokenEndpoint,
+	}, nil
+}
+
+func (provider *oidcProvider) Scopes() []string {
+	return []string{"openid", "email", "profile"}
+}
+
+func (provider *oidcProvider) GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error) {
+	_, verifier, err := provider.discover(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+
+	if !ok || raw == "" {
+		return nil, fmt.Errorf("id token is missing in token response")
+	}
+
+	var claims IDTokenClaims
+	err = verifier.Verify(ctx, raw, request.Nonce, &claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NormalizeProfile(claims), nil
+}
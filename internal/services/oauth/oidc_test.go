@@ -0,0 +1,274 @@
+package ouathservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testClientID = "client"
+	testNonce    = "nonce"
+	testKeyID    = "key"
+)
+
+// newTestKeySet serves public key of returned signing key as JWKS
+func newTestKeySet(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jsonWebKeySet{Keys: []jsonWebKey{{
+			Kty: "RSA",
+			Kid: testKeyID,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+
+	t.Cleanup(server.Close)
+
+	return key, server.URL
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+
+	if err != nil {
+		t.Fatalf("cannot sign token: %v", err)
+	}
+
+	return signed
+}
+
+func TestIDTokenVerifier(t *testing.T) {
+	key, jwksURL := newTestKeySet(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            testIssuer,
+			"sub":            "42",
+			"aud":            testClientID,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          testNonce,
+			"email":          "alice@example.com",
+			"email_verified": true,
+		}
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		valid bool
+	}{
+		{
+			name:  "valid token",
+			token: func() string { return signTestToken(t, key, testKeyID, validClaims()) },
+			valid: true,
+		},
+		{
+			name: "audience list containing client",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = []string{"another", testClientID}
+				return signTestToken(t, key, testKeyID, claims)
+			},
+			valid: true,
+		},
+		{
+			name: "another issuer",
+			token: func() string {
+				claims := validClaims()
+				claims["iss"] = "https://evil.example.com"
+				return signTestToken(t, key, testKeyID, claims)
+			},
+		},
+		{
+			name: "another audience",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = "another"
+				return signTestToken(t, key, testKeyID, claims)
+			},
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := validClaims()
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return signTestToken(t, key, testKeyID, claims)
+			},
+		},
+		{
+			name: "without expiration",
+			token: func() string {
+				claims := validClaims()
+				delete(claims, "exp")
+				return signTestToken(t, key, testKeyID, claims)
+			},
+		},
+		{
+			name: "another nonce",
+			token: func() string {
+				claims := validClaims()
+				claims["nonce"] = "replayed"
+				return signTestToken(t, key, testKeyID, claims)
+			},
+		},
+		{
+			name: "without nonce",
+			token: func() string {
+				claims := validClaims()
+				delete(claims, "nonce")
+				return signTestToken(t, key, testKeyID, claims)
+			},
+		},
+		{
+			name:  "signed with another key",
+			token: func() string { return signTestToken(t, otherKey, testKeyID, validClaims()) },
+		},
+		{
+			name:  "unknown key id",
+			token: func() string { return signTestToken(t, key, "unknown", validClaims()) },
+		},
+		{
+			name: "hmac signature",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+				token.Header["kid"] = testKeyID
+				signed, _ := token.SignedString([]byte("secret"))
+				return signed
+			},
+		},
+		{
+			name: "unsigned",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims())
+				token.Header["kid"] = testKeyID
+				signed, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return signed
+			},
+		},
+	}
+
+	verifier := newIDTokenVerifier(testIssuer, jwksURL, testClientID)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var claims IDTokenClaims
+			err := verifier.Verify(context.Background(), test.token(), testNonce, &claims)
+
+			if test.valid && err != nil {
+				t.Fatalf("expected valid token, got %v", err)
+			}
+
+			if !test.valid && err == nil {
+				t.Fatal("expected invalid token")
+			}
+
+			if test.valid && (claims.Subject != "42" || claims.Email != "alice@example.com" || !claims.EmailVerified) {
+				t.Fatalf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestNativeIDTokenVerifier(t *testing.T) {
+	key, jwksURL := newTestKeySet(t)
+
+	const nativeClientID = "ios"
+
+	hash := sha256.Sum256([]byte(testNonce))
+	hashedNonce := hex.EncodeToString(hash[:])
+
+	tokenWith := func(audience string, nonce string) string {
+		return signTestToken(t, key, testKeyID, jwt.MapClaims{
+			"iss":   testIssuer,
+			"sub":   "42",
+			"aud":   audience,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": nonce,
+		})
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		validWeb    bool
+		validNative bool
+	}{
+		{
+			name:        "web client audience",
+			token:       tokenWith(testClientID, testNonce),
+			validWeb:    true,
+			validNative: true,
+		},
+		{
+			name:        "native client audience",
+			token:       tokenWith(nativeClientID, testNonce),
+			validNative: true,
+		},
+		{
+			name:        "hashed nonce",
+			token:       tokenWith(nativeClientID, hashedNonce),
+			validNative: true,
+		},
+		{
+			name:  "another audience",
+			token: tokenWith("another", testNonce),
+		},
+		{
+			name:  "hash of another nonce",
+			token: tokenWith(nativeClientID, hex.EncodeToString(make([]byte, sha256.Size))),
+		},
+	}
+
+	web := newIDTokenVerifier(testIssuer, jwksURL, testClientID)
+	native := web.native([]string{nativeClientID})
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var claims IDTokenClaims
+
+			if err := web.Verify(context.Background(), test.token, testNonce, &claims); (err == nil) != test.validWeb {
+				t.Fatalf("web verifier: expected valid %t, got %v", test.validWeb, err)
+			}
+
+			if err := native.Verify(context.Background(), test.token, testNonce, &claims); (err == nil) != test.validNative {
+				t.Fatalf("native verifier: expected valid %t, got %v", test.validNative, err)
+			}
+		})
+	}
+}
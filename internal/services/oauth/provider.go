@@ -0,0 +1,158 @@
+package ouathservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"oauth-go/internal/types"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// httpClient is used for discovery and JWKS requests made outside of provider context,
+// provider requests go through upstream client of the provider
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// AuthRequest holds values generated for a single sign-in
+// which have to be checked again on callback
+type AuthRequest struct {
+	State string
+	Nonce string
+	// PKCE code verifier, S256 challenge is sent with sign-in url
+	CodeVerifier string
+	// extra scopes requested on top of provider defaults
+	Scopes []string
+	// user json posted to form_post callback, apple sends it on first authorization only
+	User string
+	// registered redirect url selected on sign-in, <NAME>_REDIRECT_URL when empty
+	RedirectURL string
+}
+
+// Provider is a single identity provider implementation.
+// To add a new provider implement this interface and call Register
+// from the init function of the provider file.
+type Provider interface {
+	// Endpoint returns provider authorization and token urls
+	Endpoint(ctx context.Context) (oauth2.Endpoint, error)
+	// Scopes returns scopes requested during sign-in
+	Scopes() []string
+	// GetProfile fetches user profile with authorized client
+	// and normalizes it into ProfileImpl
+	GetProfile(ctx context.Context, client *http.Client, token *oauth2.Token, request *AuthRequest) (*ProfileImpl, error)
+}
+
+// ClientSecretSource is implemented by providers which generate client secret
+// on each token request instead of static <NAME>_CLIENT_SECRET
+type ClientSecretSource interface {
+	ClientSecret() (string, error)
+}
+
+// AuthURLOptioner is implemented by providers which need extra sign-in url parameters,
+// e.g. response_mode=form_post
+type AuthURLOptioner interface {
+	AuthURLOptions() []oauth2.AuthCodeOption
+}
+
+// Admitter is implemented by providers with own admission rules,
+// errors have to wrap ErrAccessDenied
+type Admitter interface {
+	Admit(profile *ProfileImpl) error
+}
+
+// IDTokenProvider is implemented by providers which can sign in
+// with id token issued to native apps by provider sdk
+type IDTokenProvider interface {
+	VerifyIDToken(ctx context.Context, raw string, nonce string) (*ProfileImpl, error)
+}
+
+// ProviderFactory creates provider from its config
+type ProviderFactory func(config *types.ProviderConfig) (Provider, error)
+
+var registry = map[string]ProviderFactory{}
+
+// Register makes provider available to be enabled with OAUTH_PROVIDERS
+func Register(name string, factory ProviderFactory) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("oauth provider %s is already registered", name))
+	}
+
+	registry[name] = factory
+}
+
+// RegisteredProviders returns names of all known providers
+func RegisteredProviders() []string {
+	names := make([]string, 0, len(registry))
+
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// getJSON requests url and decodes successful json response into out
+func getJSON(ctx context.Context, client *http.Client, url string, out any) error {
+	_, err := getJSONWithHeader(ctx, client, url, out)
+
+	return err
+}
+
+// getJSONWithHeader is getJSON returning response headers, e.g. pagination links
+func getJSONWithHeader(ctx context.Context, client *http.Client, url string, out any) (http.Header, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: status %d from %s", ErrProviderUnavailable, response.StatusCode, url)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", response.StatusCode, url)
+	}
+
+	// error pages of proxies and captive portals are not parsed as empty profiles
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil, fmt.Errorf("unexpected content type %q from %s", mediaType, url)
+	}
+
+	return response.Header, json.Unmarshal(body, out)
+}
+
+// fetchProfile requests profile url and normalizes response into ProfileImpl
+func fetchProfile[T Profile](ctx context.Context, client *http.Client, url string) (*ProfileImpl, error) {
+	var info T
+	err := getJSON(ctx, client, url, &info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NormalizeProfile(info), nil
+}
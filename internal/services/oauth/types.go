@@ -1,68 +1,65 @@
 package ouathservice
 
-import "strconv"
+import "errors"
+
+var (
+	// ErrNoVerifiedEmail is returned when provider account has no verified email
+	ErrNoVerifiedEmail = errors.New("provider account has no verified email")
+	// ErrAccessDenied is returned when provider account does not pass admission rules
+	ErrAccessDenied = errors.New("access denied by admission rules")
+	// ErrIDTokenNotSupported is returned when provider cannot sign in with id token
+	ErrIDTokenNotSupported = errors.New("provider does not support id token sign-in")
+	// ErrProviderUnavailable is returned when provider does not respond,
+	// fails with 5xx or its circuit breaker is open
+	ErrProviderUnavailable = errors.New("provider is unavailable")
+)
 
 type Profile interface {
 	GetID() string
 	GetEmail() string
+	IsEmailVerified() bool
 	GetName() string
 	GetAvatarURL() string
 }
 
+// authMethodsProfile is profile of id token carrying amr claim
+type authMethodsProfile interface {
+	GetAMR() []string
+}
+
 type ProfileImpl struct {
 	ID        string `json:"id"`
 	Email     string `json:"email"`
 	Name      string `json:"name"`
 	AvatarURL string `json:"avatar_url"`
+	// true only when provider confirmed that user owns the email
+	EmailVerified bool `json:"email_verified"`
+	// all verified emails of the account, when provider exposes them
+	VerifiedEmails []string `json:"verified_emails,omitempty"`
+	// google workspace domain of the account
+	HostedDomain string `json:"hosted_domain,omitempty"`
+	// github organizations and teams as org/team, fetched only when rules use them
+	Organizations []string `json:"organizations,omitempty"`
+	Teams         []string `json:"teams,omitempty"`
+	// google group emails, fetched only when GOOGLE_FETCH_GROUPS is set
+	Groups []string `json:"groups,omitempty"`
+	// authentication methods of upstream sign-in, only when id token has amr claim
+	AMR []string `json:"amr,omitempty"`
 }
 
-type GoogleProfile struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Name          string `json:"name"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	Picture       string `json:"picture"`
-	Locale        string `json:"locale"`
-}
-
-func (g GoogleProfile) GetID() string {
-	return g.ID
-}
-
-func (g GoogleProfile) GetEmail() string {
-	return g.Email
-}
-
-func (g GoogleProfile) GetName() string {
-	return g.Name
-}
-
-func (g GoogleProfile) GetAvatarURL() string {
-	return g.Picture
-}
-
-type GithubProfile struct {
-	ID        int    `json:"id"`
-	Login     string `json:"login"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
-}
-
-func (g GithubProfile) GetID() string {
-	return strconv.Itoa(g.ID)
-}
+// NormalizeProfile converts provider specific profile into ProfileImpl
+func NormalizeProfile(profile Profile) *ProfileImpl {
+	impl := &ProfileImpl{
+		ID:            profile.GetID(),
+		Email:         profile.GetEmail(),
+		Name:          profile.GetName(),
+		AvatarURL:     profile.GetAvatarURL(),
+		EmailVerified: profile.GetEmail() != "" && profile.IsEmailVerified(),
+	}
 
-func (g GithubProfile) GetEmail() string {
-	return g.Email
-}
-
-func (g GithubProfile) GetName() string {
-	return g.Name
-}
+	if withAMR, ok := profile.(authMethodsProfile); ok {
+		impl.AMR = withAMR.GetAMR()
+	}
 
-func (g GithubProfile) GetAvatarURL() string {
-	return g.AvatarURL
+	return impl
 }
@@ -0,0 +1,293 @@
+package ouathservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"oauth-go/internal/types"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// backoff before first retry, doubled on each next one
+const upstreamRetryBackoff = 200 * time.Millisecond
+
+// UpstreamStats are counters of requests made to a single provider
+type UpstreamStats struct {
+	Provider string `json:"provider" example:"github"`
+	// circuit breaker state, closed, open or half_open
+	State string `json:"state" example:"closed"`
+	// attempts sent to provider, retries included
+	Requests int64 `json:"requests"`
+	// attempts failed with network error, timeout or 5xx
+	Failures int64 `json:"failures"`
+	Retries  int64 `json:"retries"`
+	// requests rejected without calling provider while circuit was open
+	Rejected         int64      `json:"rejected"`
+	AverageLatencyMs int64      `json:"average_latency_ms"`
+	LastError        string     `json:"last_error,omitempty"`
+	LastFailureAt    *time.Time `json:"last_failure_at,omitempty"`
+}
+
+// upstream is http transport of a single provider, it limits each attempt with timeout,
+// retries idempotent requests and stops calling provider after consecutive failures
+type upstream struct {
+	provider  string
+	base      http.RoundTripper
+	client    *http.Client
+	timeout   time.Duration
+	retries   int
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// half open circuit lets a single probe request through
+	probing bool
+	stats   UpstreamStats
+	latency time.Duration
+}
+
+func newUpstream(provider string, config *types.AppConfig) *upstream {
+	upstream := &upstream{
+		provider:  provider,
+		base:      http.DefaultTransport,
+		timeout:   time.Duration(config.UpstreamTimeout) * time.Second,
+		retries:   config.UpstreamRetries,
+		threshold: config.UpstreamBreakerThreshold,
+		cooldown:  time.Duration(config.UpstreamBreakerCooldown) * time.Second,
+		state:     CircuitClosed,
+	}
+
+	upstream.client = &http.Client{Transport: upstream}
+
+	return upstream
+}
+
+// withUpstream puts provider client into context, oauth2 uses it for token requests
+// and authorized clients, discovery and jwks are fetched with it too
+func (oauth *OAuth) withUpstream(ctx context.Context, provider string) context.Context {
+	upstream, ok := oauth.upstreams[provider]
+
+	if !ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, oauth2.HTTPClient, upstream.client)
+}
+
+// upstreamClient returns provider client of context, httpClient when there is none
+func upstreamClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		return client
+	}
+
+	return httpClient
+}
+
+// UpstreamStats returns request counters and circuit state of enabled providers
+func (oauth *OAuth) UpstreamStats() []UpstreamStats {
+	stats := make([]UpstreamStats, 0, len(oauth.upstreams))
+
+	for _, name := range oauth.EnabledProviders() {
+		stats = append(stats, oauth.upstreams[name].Stats())
+	}
+
+	return stats
+}
+
+func (upstream *upstream) RoundTrip(request *http.Request) (*http.Response, error) {
+	attempts := 1
+
+	// token requests are not retried, authorization code can be used only once
+	if request.Method == http.MethodGet || request.Method == http.MethodHead {
+		attempts += upstream.retries
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := upstream.allow()
+
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := upstream.attempt(request)
+
+		if err == nil && !isRetryableStatus(response.StatusCode) {
+			return response, nil
+		}
+
+		if attempt >= attempts || request.Context().Err() != nil {
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+			}
+
+			return response, nil
+		}
+
+		if response != nil {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		upstream.mu.Lock()
+		upstream.stats.Retries++
+		upstream.mu.Unlock()
+
+		select {
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		case <-time.After(upstreamRetryBackoff << (attempt - 1)):
+		}
+	}
+}
+
+// attempt sends request once with own timeout and records its outcome
+func (upstream *upstream) attempt(request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(request.Context(), upstream.timeout)
+	started := time.Now()
+
+	response, err := upstream.base.RoundTrip(request.Clone(ctx))
+
+	switch {
+	case err != nil && request.Context().Err() != nil:
+		// request is cancelled by caller, provider is not to blame
+		upstream.release()
+	case err != nil:
+		upstream.record(started, err)
+	case response.StatusCode >= http.StatusInternalServerError:
+		upstream.record(started, fmt.Errorf("status %d", response.StatusCode))
+	default:
+		upstream.record(started, nil)
+	}
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// timeout covers reading of body too, so context is cancelled once body is closed
+	response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+
+	return response, nil
+}
+
+// allow rejects request while circuit is open, after cooldown a single probe is let through
+func (upstream *upstream) allow() error {
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+
+	if upstream.state == CircuitOpen {
+		if time.Since(upstream.openedAt) < upstream.cooldown {
+			upstream.stats.Rejected++
+			return fmt.Errorf("%w: %s circuit is open", ErrProviderUnavailable, upstream.provider)
+		}
+
+		upstream.state = CircuitHalfOpen
+		upstream.probing = false
+	}
+
+	if upstream.state == CircuitHalfOpen {
+		if upstream.probing {
+			upstream.stats.Rejected++
+			return fmt.Errorf("%w: %s circuit is half open", ErrProviderUnavailable, upstream.provider)
+		}
+
+		upstream.probing = true
+	}
+
+	return nil
+}
+
+// record updates counters and circuit state with outcome of attempt, err is nil on success
+func (upstream *upstream) record(started time.Time, err error) {
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+
+	upstream.stats.Requests++
+	upstream.latency += time.Since(started)
+	upstream.probing = false
+
+	if err == nil {
+		upstream.failures = 0
+		upstream.state = CircuitClosed
+		return
+	}
+
+	now := time.Now()
+
+	upstream.failures++
+	upstream.stats.Failures++
+	upstream.stats.LastError = err.Error()
+	upstream.stats.LastFailureAt = &now
+
+	if upstream.state == CircuitHalfOpen || upstream.failures >= upstream.threshold {
+		upstream.state = CircuitOpen
+		upstream.openedAt = now
+	}
+}
+
+// release frees probe slot of attempt cancelled by caller without changing circuit state
+func (upstream *upstream) release() {
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+
+	upstream.stats.Requests++
+	upstream.probing = false
+}
+
+// Stats returns copy of upstream counters
+func (upstream *upstream) Stats() UpstreamStats {
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+
+	stats := upstream.stats
+	stats.Provider = upstream.provider
+	stats.State = upstream.state
+
+	if stats.Requests > 0 {
+		stats.AverageLatencyMs = upstream.latency.Milliseconds() / stats.Requests
+	}
+
+	return stats
+}
+
+// isRetryableStatus reports whether status is a temporary provider failure
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// unavailable wraps 5xx errors of token endpoint with ErrProviderUnavailable
+func unavailable(err error) error {
+	var retrieveErr *oauth2.RetrieveError
+
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil && retrieveErr.Response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+
+	return err
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+
+	return err
+}
@@ -1,19 +1,43 @@
 package services
 
 import (
+	"log/slog"
+	claimsservice "oauth-go/internal/services/claims"
+	eventsservice "oauth-go/internal/services/events"
+	issuerservice "oauth-go/internal/services/issuer"
 	jwtservice "oauth-go/internal/services/jwt"
 	ouathservice "oauth-go/internal/services/oauth"
 	"oauth-go/internal/types"
+
+	"github.com/redis/go-redis/v9"
 )
 
 type Services struct {
-	OAuth *ouathservice.OAuth
-	Jwt   *jwtservice.Jwt
+	OAuth  *ouathservice.OAuth
+	Jwt    *jwtservice.Jwt
+	Events *eventsservice.Events
+	Claims *claimsservice.Claims
+	Issuer *issuerservice.Issuer
 }
 
-func New(config *types.AppConfig) *Services {
-	return &Services{
-		OAuth: ouathservice.New(config),
-		Jwt:   jwtservice.New(config),
+func New(config *types.AppConfig, logger *slog.Logger, rdb *redis.Client) (*Services, error) {
+	claims, err := claimsservice.New(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, err := issuerservice.New(config, logger)
+
+	if err != nil {
+		return nil, err
 	}
+
+	return &Services{
+		OAuth:  ouathservice.New(config, logger),
+		Jwt:    jwtservice.New(config),
+		Events: eventsservice.New(rdb),
+		Claims: claims,
+		Issuer: issuer,
+	}, nil
 }
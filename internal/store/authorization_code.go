@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const authorizationCodeKeyPrefix = "oauth:code:"
+
+type AuthorizationCodeStore interface {
+	CreateCode(ctx context.Context, code string, data *AuthorizationCode, ttl time.Duration) error
+	ConsumeCode(ctx context.Context, code string) (*AuthorizationCode, error)
+}
+
+type authorizationCodeStore struct {
+	rdb *redis.Client
+}
+
+// AuthorizationCode is issued by /oauth/authorize to client,
+// client swaps it for tokens on /oauth/token
+type AuthorizationCode struct {
+	ClientID    string   `json:"client_id"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+	UserID      int      `json:"user_id"`
+	SessionID   int      `json:"session_id"`
+	// PKCE S256 challenge, empty when confidential client does not use PKCE
+	CodeChallenge string    `json:"code_challenge,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	// OpenID Connect nonce, sign-in time and upstream provider of sso session
+	Nonce    string    `json:"nonce,omitempty"`
+	AuthTime time.Time `json:"auth_time"`
+	Provider string    `json:"provider"`
+	AMR      []string  `json:"amr,omitempty"`
+}
+
+func NewAuthorizationCodeStore(rdb *redis.Client) *authorizationCodeStore {
+	return &authorizationCodeStore{
+		rdb: rdb,
+	}
+}
+
+func (store *authorizationCodeStore) CreateCode(ctx context.Context, code string, data *AuthorizationCode, ttl time.Duration) error {
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("cannot encode authorization code: %w", err)
+	}
+
+	created, err := store.rdb.SetNX(ctx, authorizationCodeKeyPrefix+code, value, ttl).Result()
+
+	if err != nil {
+		return fmt.Errorf("cannot save authorization code: %w", err)
+	}
+
+	if !created {
+		return fmt.Errorf("authorization code already exists")
+	}
+
+	return nil
+}
+
+// ConsumeCode atomically returns and deletes authorization code,
+// so the same code cannot be used twice
+func (store *authorizationCodeStore) ConsumeCode(ctx context.Context, code string) (*AuthorizationCode, error) {
+	value, err := store.rdb.GetDel(ctx, authorizationCodeKeyPrefix+code).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("authorization code not found")
+		}
+
+		return nil, fmt.Errorf("cannot get authorization code: %w", err)
+	}
+
+	var data AuthorizationCode
+	err = json.Unmarshal(value, &data)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode authorization code: %w", err)
+	}
+
+	return &data, nil
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	deviceCodeKeyPrefix = "oauth:device:"
+	// user code points to device code, user types it on verification page
+	deviceUserCodeKeyPrefix = "oauth:device:user:"
+	// set for polling interval on each token request, polling while it exists is too fast
+	devicePollKeyPrefix = "oauth:device:poll:"
+)
+
+var ErrDeviceCodeNotFound = errors.New("device code not found")
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCodeStore keeps device authorization requests (RFC 8628)
+// until user approves them on verification page and device polls tokens
+type DeviceCodeStore interface {
+	CreateDeviceCode(ctx context.Context, deviceCode string, data *DeviceCode, ttl time.Duration) error
+	GetDeviceCode(ctx context.Context, deviceCode string) (*DeviceCode, error)
+	GetDeviceCodeByUserCode(ctx context.Context, userCode string) (string, *DeviceCode, error)
+	DecideDeviceCode(ctx context.Context, deviceCode string, data *DeviceCode) error
+	ConsumeDeviceCode(ctx context.Context, deviceCode string) (*DeviceCode, error)
+	PollDeviceCode(ctx context.Context, deviceCode string, interval time.Duration) (bool, error)
+}
+
+type deviceCodeStore struct {
+	rdb *redis.Client
+}
+
+type DeviceCode struct {
+	ClientID  string    `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	UserCode  string    `json:"user_code"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// set when user approves device on verification page
+	UserID    int       `json:"user_id,omitempty"`
+	SessionID int       `json:"session_id,omitempty"`
+	AuthTime  time.Time `json:"auth_time"`
+	Provider  string    `json:"provider,omitempty"`
+	AMR       []string  `json:"amr,omitempty"`
+}
+
+func NewDeviceCodeStore(rdb *redis.Client) *deviceCodeStore {
+	return &deviceCodeStore{
+		rdb: rdb,
+	}
+}
+
+func (store *deviceCodeStore) CreateDeviceCode(ctx context.Context, deviceCode string, data *DeviceCode, ttl time.Duration) error {
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("cannot encode device code: %w", err)
+	}
+
+	// user codes are short, so collision is possible and must not overwrite another request
+	created, err := store.rdb.SetNX(ctx, deviceUserCodeKeyPrefix+data.UserCode, deviceCode, ttl).Result()
+
+	if err != nil {
+		return fmt.Errorf("cannot save user code: %w", err)
+	}
+
+	if !created {
+		return fmt.Errorf("user code already exists")
+	}
+
+	created, err = store.rdb.SetNX(ctx, deviceCodeKeyPrefix+deviceCode, value, ttl).Result()
+
+	if err != nil {
+		return fmt.Errorf("cannot save device code: %w", err)
+	}
+
+	if !created {
+		return fmt.Errorf("device code already exists")
+	}
+
+	return nil
+}
+
+func (store *deviceCodeStore) GetDeviceCode(ctx context.Context, deviceCode string) (*DeviceCode, error) {
+	value, err := store.rdb.Get(ctx, deviceCodeKeyPrefix+deviceCode).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrDeviceCodeNotFound
+		}
+
+		return nil, fmt.Errorf("cannot get device code: %w", err)
+	}
+
+	var data DeviceCode
+	err = json.Unmarshal(value, &data)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode device code: %w", err)
+	}
+
+	return &data, nil
+}
+
+// GetDeviceCodeByUserCode returns device code and its request by user code
+func (store *deviceCodeStore) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (string, *DeviceCode, error) {
+	deviceCode, err := store.rdb.Get(ctx, deviceUserCodeKeyPrefix+userCode).Result()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", nil, ErrDeviceCodeNotFound
+		}
+
+		return "", nil, fmt.Errorf("cannot get user code: %w", err)
+	}
+
+	data, err := store.GetDeviceCode(ctx, deviceCode)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return deviceCode, data, nil
+}
+
+// decideDeviceCodeScript replaces device code only while it is pending, expiration is kept
+var decideDeviceCodeScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+
+if not value or cjson.decode(value).status ~= ARGV[1] then
+	return 0
+end
+
+redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+
+return 1
+`)
+
+// DecideDeviceCode atomically saves approval or denial of pending device code,
+// so concurrent decisions cannot overwrite each other
+func (store *deviceCodeStore) DecideDeviceCode(ctx context.Context, deviceCode string, data *DeviceCode) error {
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("cannot encode device code: %w", err)
+	}
+
+	saved, err := decideDeviceCodeScript.Run(ctx, store.rdb, []string{deviceCodeKeyPrefix + deviceCode}, DeviceCodePending, value).Int()
+
+	if err != nil {
+		return fmt.Errorf("cannot save device code: %w", err)
+	}
+
+	if saved == 0 {
+		return ErrDeviceCodeNotFound
+	}
+
+	return nil
+}
+
+// ConsumeDeviceCode atomically returns and deletes device code,
+// so tokens of approved device are issued only once
+func (store *deviceCodeStore) ConsumeDeviceCode(ctx context.Context, deviceCode string) (*DeviceCode, error) {
+	value, err := store.rdb.GetDel(ctx, deviceCodeKeyPrefix+deviceCode).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrDeviceCodeNotFound
+		}
+
+		return nil, fmt.Errorf("cannot get device code: %w", err)
+	}
+
+	var data DeviceCode
+	err = json.Unmarshal(value, &data)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode device code: %w", err)
+	}
+
+	store.rdb.Del(ctx, deviceUserCodeKeyPrefix+data.UserCode)
+
+	return &data, nil
+}
+
+// PollDeviceCode records token request of device, false is returned
+// when previous request was made less than interval ago
+func (store *deviceCodeStore) PollDeviceCode(ctx context.Context, deviceCode string, interval time.Duration) (bool, error) {
+	allowed, err := store.rdb.SetNX(ctx, devicePollKeyPrefix+deviceCode, 1, interval).Result()
+
+	if err != nil {
+		return false, fmt.Errorf("cannot save device poll: %w", err)
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const exchangeCodeKeyPrefix = "auth:exchange:"
+
+type ExchangeCodeStore interface {
+	CreateCode(ctx context.Context, code string, data *ExchangeCode, ttl time.Duration) error
+	ConsumeCode(ctx context.Context, code string) (*ExchangeCode, error)
+}
+
+type exchangeCodeStore struct {
+	rdb *redis.Client
+}
+
+// ExchangeCode is a signed in session which frontend swaps for tokens,
+// tokens are issued on exchange, so they are never stored
+type ExchangeCode struct {
+	UserID    int       `json:"user_id"`
+	SessionID int       `json:"session_id"`
+	DeviceID  string    `json:"device_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// code is redeemed only with verifier of this challenge, or with
+	// binding cookie of browser which signed in when there is no challenge
+	CodeChallenge string `json:"code_challenge,omitempty"`
+	BindingHash   string `json:"binding_hash"`
+}
+
+func NewExchangeCodeStore(rdb *redis.Client) *exchangeCodeStore {
+	return &exchangeCodeStore{
+		rdb: rdb,
+	}
+}
+
+func (store *exchangeCodeStore) CreateCode(ctx context.Context, code string, data *ExchangeCode, ttl time.Duration) error {
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("cannot encode exchange code: %w", err)
+	}
+
+	created, err := store.rdb.SetNX(ctx, exchangeCodeKeyPrefix+code, value, ttl).Result()
+
+	if err != nil {
+		return fmt.Errorf("cannot save exchange code: %w", err)
+	}
+
+	if !created {
+		return fmt.Errorf("exchange code already exists")
+	}
+
+	return nil
+}
+
+// ConsumeCode atomically returns and deletes exchange code,
+// so the same code cannot be used twice
+func (store *exchangeCodeStore) ConsumeCode(ctx context.Context, code string) (*ExchangeCode, error) {
+	value, err := store.rdb.GetDel(ctx, exchangeCodeKeyPrefix+code).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("exchange code not found")
+		}
+
+		return nil, fmt.Errorf("cannot get exchange code: %w", err)
+	}
+
+	var data ExchangeCode
+	err = json.Unmarshal(value, &data)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode exchange code: %w", err)
+	}
+
+	return &data, nil
+}
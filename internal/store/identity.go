@@ -0,0 +1,259 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrLastIdentity     = errors.New("cannot delete last identity of user")
+)
+
+type IdentityStore interface {
+	CreateIdentity(ctx context.Context, dto *UserIdentityDto) (*UserIdentity, error)
+	GetIdentityBy(ctx context.Context, filters map[string]any) (*UserIdentity, error)
+	GetIdentitiesBy(ctx context.Context, filters map[string]any) ([]*UserIdentity, error)
+	UpdateIdentityEmail(ctx context.Context, identityID int, email string, verified bool) error
+	UpdateIdentityScopes(ctx context.Context, identityID int, scopes []string) error
+	UpdateIdentityClaims(ctx context.Context, identityID int, roles []string, attributes map[string]string) error
+	DeleteIdentity(ctx context.Context, userID int, identityID int) error
+}
+
+type identityStore struct {
+	db *pgxpool.Pool
+}
+
+// UserIdentity is a provider account linked to user,
+// single user can sign in with several identities
+type UserIdentity struct {
+	ID             int       `db:"id" json:"id"`
+	UserID         int       `db:"user_id" json:"user_id"`
+	Provider       string    `db:"provider" json:"provider"`
+	ProviderUserID string    `db:"provider_user_id" json:"provider_user_id"`
+	Email          *string   `db:"email" json:"email,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"-"`
+	EmailVerified  bool      `db:"email_verified" json:"email_verified"`
+	// space separated scopes granted by provider
+	Scopes string `db:"scopes" json:"scopes"`
+	// granted by claims mapping rules on last login with identity
+	Roles      []string          `db:"roles" json:"-"`
+	Attributes map[string]string `db:"attributes" json:"-"`
+}
+
+type UserIdentityDto struct {
+	UserID         int
+	Provider       string
+	ProviderUserID string
+	Email          string
+	EmailVerified  bool
+}
+
+func NewIdentityStore(db *pgxpool.Pool) *identityStore {
+	return &identityStore{
+		db: db,
+	}
+}
+
+func identityRecord(dto *UserIdentityDto) goqu.Record {
+	return goqu.Record{
+		"user_id":          dto.UserID,
+		"provider":         dto.Provider,
+		"provider_user_id": dto.ProviderUserID,
+		"email":            dto.Email,
+		"email_verified":   dto.EmailVerified,
+	}
+}
+
+func (store *identityStore) CreateIdentity(ctx context.Context, dto *UserIdentityDto) (*UserIdentity, error) {
+	sql, _, _ := goqu.Insert("user_identities").
+		Rows(identityRecord(dto)).
+		Returning("*").ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	identity, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[UserIdentity])
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return identity, nil
+}
+
+func (store *identityStore) GetIdentityBy(ctx context.Context, filters map[string]any) (*UserIdentity, error) {
+	query := goqu.From("user_identities")
+
+	for key, value := range filters {
+		query = query.Where(goqu.I(key).Eq(value))
+	}
+
+	sql, _, _ := query.ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	identity, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[UserIdentity])
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrIdentityNotFound, filters)
+		}
+
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return identity, nil
+}
+
+func (store *identityStore) GetIdentitiesBy(ctx context.Context, filters map[string]any) ([]*UserIdentity, error) {
+	query := goqu.From("user_identities")
+
+	for key, value := range filters {
+		query = query.Where(goqu.I(key).Eq(value))
+	}
+
+	sql, _, _ := query.Order(goqu.I("created_at").Asc()).ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	identities, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByPos[UserIdentity])
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return identities, nil
+}
+
+// UpdateIdentityEmail stores email and its verification status reported by provider
+func (store *identityStore) UpdateIdentityEmail(ctx context.Context, identityID int, email string, verified bool) error {
+	sql, _, _ := goqu.Update("user_identities").
+		Set(goqu.Record{
+			"email":          email,
+			"email_verified": verified,
+			"updated_at":     time.Now(),
+		}).
+		Where(goqu.I("id").Eq(identityID)).ToSQL()
+
+	_, err := store.db.Exec(ctx, sql)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return nil
+}
+
+func (store *identityStore) UpdateIdentityScopes(ctx context.Context, identityID int, scopes []string) error {
+	sql, _, _ := goqu.Update("user_identities").
+		Set(goqu.Record{
+			"scopes":     strings.Join(scopes, " "),
+			"updated_at": time.Now(),
+		}).
+		Where(goqu.I("id").Eq(identityID)).ToSQL()
+
+	_, err := store.db.Exec(ctx, sql)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return nil
+}
+
+func (store *identityStore) UpdateIdentityClaims(ctx context.Context, identityID int, roles []string, attributes map[string]string) error {
+	rolesJSON, _ := json.Marshal(roles)
+	attributesJSON, _ := json.Marshal(attributes)
+
+	sql, _, _ := goqu.Update("user_identities").
+		Set(goqu.Record{
+			"roles":      string(rolesJSON),
+			"attributes": string(attributesJSON),
+			"updated_at": time.Now(),
+		}).
+		Where(goqu.I("id").Eq(identityID)).ToSQL()
+
+	_, err := store.db.Exec(ctx, sql)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteIdentity unlinks identity from user, user row is locked
+// so concurrent requests cannot remove all identities of the user.
+// Provider tokens of identity are removed by ON DELETE CASCADE
+func (store *identityStore) DeleteIdentity(ctx context.Context, userID int, identityID int) error {
+	tx, err := store.db.Begin(ctx)
+
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	sql, _, _ := goqu.From("users").
+		Select("id").
+		Where(goqu.I("id").Eq(userID)).
+		ForUpdate(goqu.Wait).ToSQL()
+
+	_, err = tx.Exec(ctx, sql)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	sql, _, _ = goqu.From("user_identities").
+		Select(goqu.COUNT("*")).
+		Where(goqu.I("user_id").Eq(userID)).ToSQL()
+
+	var count int
+	err = tx.QueryRow(ctx, sql).Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	if count <= 1 {
+		return ErrLastIdentity
+	}
+
+	sql, _, _ = goqu.Delete("user_identities").
+		Where(
+			goqu.I("id").Eq(identityID),
+			goqu.I("user_id").Eq(userID),
+		).ToSQL()
+
+	result, err := tx.Exec(ctx, sql)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+
+	return tx.Commit(ctx)
+}
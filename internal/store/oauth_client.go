@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"oauth-go/internal/types"
+	"slices"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrClientNotFound = errors.New("oauth client not found")
+
+// OAuthClientStore keeps applications which sign users in with this service,
+// client secrets are stored as hashes and returned only once on creation
+type OAuthClientStore interface {
+	CreateClient(ctx context.Context, dto *OAuthClientDto) (*OAuthClient, error)
+	GetClientBy(ctx context.Context, filters map[string]any) (*OAuthClient, error)
+	GetClientsBy(ctx context.Context, filters map[string]any) ([]*OAuthClient, error)
+	DeleteClientBy(ctx context.Context, filters map[string]any) error
+}
+
+type oauthClientStore struct {
+	db *pgxpool.Pool
+}
+
+type OAuthClient struct {
+	ID               int        `db:"id" json:"-"`
+	ClientID         string     `db:"client_id" json:"client_id"`
+	ClientSecretHash *string    `db:"client_secret_hash" json:"-"`
+	Name             string     `db:"name" json:"name"`
+	ClientType       string     `db:"client_type" json:"client_type"`
+	RedirectURIs     []string   `db:"redirect_uris" json:"redirect_uris"`
+	GrantTypes       []string   `db:"grant_types" json:"grant_types"`
+	Scopes           []string   `db:"scopes" json:"scopes"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt        time.Time  `db:"updated_at" json:"-"`
+	DeletedAt        *time.Time `db:"deleted_at" json:"-"`
+	// first-party client, authorize skips consent page
+	Trusted bool `db:"trusted" json:"trusted"`
+}
+
+type OAuthClientDto struct {
+	ClientID string
+	// plain secret, empty for public clients
+	ClientSecret string
+	Name         string
+	ClientType   string
+	RedirectURIs []string
+	GrantTypes   []string
+	Scopes       []string
+	Trusted      bool
+}
+
+func NewOAuthClientStore(db *pgxpool.Pool) *oauthClientStore {
+	return &oauthClientStore{
+		db: db,
+	}
+}
+
+// HashClientSecret returns sha256 hex of secret, secrets are random
+// so fast hash is enough and lookups stay cheap on each token request
+func HashClientSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func (client *OAuthClient) IsPublic() bool {
+	return client.ClientType == types.OAuthClientPublic
+}
+
+// VerifySecret compares secret with stored hash in constant time
+func (client *OAuthClient) VerifySecret(secret string) bool {
+	if client.ClientSecretHash == nil || secret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(HashClientSecret(secret)), []byte(*client.ClientSecretHash)) == 1
+}
+
+func (client *OAuthClient) AllowsGrant(grant string) bool {
+	return slices.Contains(client.GrantTypes, grant)
+}
+
+// HasRedirectURI checks that uri exactly matches registered one, loopback uris
+// of native apps match with any port as their port is picked on each run (RFC 8252)
+func (client *OAuthClient) HasRedirectURI(uri string) bool {
+	if slices.Contains(client.RedirectURIs, uri) {
+		return true
+	}
+
+	target, err := url.Parse(uri)
+
+	if err != nil || target.Scheme != "http" || !isLoopback(target.Hostname()) {
+		return false
+	}
+
+	for _, registered := range client.RedirectURIs {
+		registeredURL, err := url.Parse(registered)
+
+		if err != nil || registeredURL.Scheme != "http" || !isLoopback(registeredURL.Hostname()) {
+			continue
+		}
+
+		if registeredURL.Hostname() == target.Hostname() && registeredURL.Path == target.Path && registeredURL.RawQuery == target.RawQuery {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+func (store *oauthClientStore) CreateClient(ctx context.Context, dto *OAuthClientDto) (*OAuthClient, error) {
+	redirectURIs, _ := json.Marshal(dto.RedirectURIs)
+	grantTypes, _ := json.Marshal(dto.GrantTypes)
+	scopes, _ := json.Marshal(dto.Scopes)
+
+	record := goqu.Record{
+		"client_id":     dto.ClientID,
+		"name":          dto.Name,
+		"client_type":   dto.ClientType,
+		"redirect_uris": string(redirectURIs),
+		"grant_types":   string(grantTypes),
+		"scopes":        string(scopes),
+		"trusted":       dto.Trusted,
+	}
+
+	if dto.ClientSecret != "" {
+		record["client_secret_hash"] = HashClientSecret(dto.ClientSecret)
+	}
+
+	sql, _, _ := goqu.Insert("oauth_clients").Rows(record).Returning("*").ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	client, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[OAuthClient])
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return client, nil
+}
+
+func (store *oauthClientStore) GetClientBy(ctx context.Context, filters map[string]any) (*OAuthClient, error) {
+	query := goqu.From("oauth_clients")
+
+	for key, value := range filters {
+		query = query.Where(goqu.I(key).Eq(value))
+	}
+
+	query = query.Where(goqu.I("deleted_at").Is(nil))
+
+	sql, _, _ := query.ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	client, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[OAuthClient])
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrClientNotFound, filters)
+		}
+
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return client, nil
+}
+
+func (store *oauthClientStore) GetClientsBy(ctx context.Context, filters map[string]any) ([]*OAuthClient, error) {
+	query := goqu.From("oauth_clients")
+
+	for key, value := range filters {
+		query = query.Where(goqu.I(key).Eq(value))
+	}
+
+	query = query.Where(goqu.I("deleted_at").Is(nil))
+
+	sql, _, _ := query.Order(goqu.I("created_at").Asc()).ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	clients, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByPos[OAuthClient])
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return clients, nil
+}
+
+func (store *oauthClientStore) DeleteClientBy(ctx context.Context, filters map[string]any) error {
+	query := goqu.Update("oauth_clients").Set(goqu.Record{"deleted_at": time.Now()})
+
+	for key, value := range filters {
+		query = query.Where(goqu.I(key).Eq(value))
+	}
+
+	query = query.Where(goqu.I("deleted_at").Is(nil))
+
+	sql, _, _ := query.ToSQL()
+
+	result, err := store.db.Exec(ctx, sql)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrClientNotFound, filters)
+	}
+
+	return nil
+}
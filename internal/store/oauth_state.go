@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const oauthStateKeyPrefix = "oauth:state:"
+
+type OAuthStateStore interface {
+	CreateState(ctx context.Context, state string, data *OAuthState, ttl time.Duration) error
+	ConsumeState(ctx context.Context, state string) (*OAuthState, error)
+}
+
+type oauthStateStore struct {
+	rdb *redis.Client
+}
+
+// OAuthState is everything sign-in request needs to remember until callback
+type OAuthState struct {
+	Provider     string `json:"provider"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+	ReturnTo     string `json:"return_to,omitempty"`
+	// extra scopes requested on top of provider defaults
+	Scopes   []string `json:"scopes,omitempty"`
+	DeviceID string   `json:"device_id"`
+	// sha256 of state binding cookie of browser which started sign-in
+	BindingHash string `json:"binding_hash"`
+	// set when authenticated user links new identity instead of signing in
+	LinkUserID int `json:"link_user_id,omitempty"`
+	// how tokens are delivered after callback, one of types.LoginDeliveryModes
+	Delivery string `json:"delivery,omitempty"`
+	// S256 challenge of frontend verifier, required on exchange of delivery=code
+	ExchangeChallenge string `json:"exchange_challenge,omitempty"`
+	// registered provider redirect url selected on sign-in, token exchange has to use the same one
+	RedirectURL string    `json:"redirect_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func NewOAuthStateStore(rdb *redis.Client) *oauthStateStore {
+	return &oauthStateStore{
+		rdb: rdb,
+	}
+}
+
+func (store *oauthStateStore) CreateState(ctx context.Context, state string, data *OAuthState, ttl time.Duration) error {
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("cannot encode oauth state: %w", err)
+	}
+
+	created, err := store.rdb.SetNX(ctx, oauthStateKeyPrefix+state, value, ttl).Result()
+
+	if err != nil {
+		return fmt.Errorf("cannot save oauth state: %w", err)
+	}
+
+	if !created {
+		return fmt.Errorf("oauth state already exists")
+	}
+
+	return nil
+}
+
+// ConsumeState atomically returns and deletes state record,
+// so the same state cannot be used twice
+func (store *oauthStateStore) ConsumeState(ctx context.Context, state string) (*OAuthState, error) {
+	value, err := store.rdb.GetDel(ctx, oauthStateKeyPrefix+state).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("oauth state not found")
+		}
+
+		return nil, fmt.Errorf("cannot get oauth state: %w", err)
+	}
+
+	var data OAuthState
+	err = json.Unmarshal(value, &data)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode oauth state: %w", err)
+	}
+
+	return &data, nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const revokedTokenKeyPrefix = "oauth:revoked:"
+
+// RevokedTokenStore is denylist of revoked access tokens, tokens are kept
+// by jti only until they expire, expired tokens are rejected anyway
+type RevokedTokenStore interface {
+	RevokeToken(ctx context.Context, jti string, ttl time.Duration) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type revokedTokenStore struct {
+	rdb *redis.Client
+}
+
+func NewRevokedTokenStore(rdb *redis.Client) *revokedTokenStore {
+	return &revokedTokenStore{
+		rdb: rdb,
+	}
+}
+
+func (store *revokedTokenStore) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	err := store.rdb.Set(ctx, revokedTokenKeyPrefix+jti, 1, ttl).Err()
+
+	if err != nil {
+		return fmt.Errorf("cannot save revoked token: %w", err)
+	}
+
+	return nil
+}
+
+func (store *revokedTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	count, err := store.rdb.Exists(ctx, revokedTokenKeyPrefix+jti).Result()
+
+	if err != nil {
+		return false, fmt.Errorf("cannot get revoked token: %w", err)
+	}
+
+	return count > 0, nil
+}
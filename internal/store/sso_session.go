@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const ssoSessionKeyPrefix = "oauth:sso:"
+
+// SSOSessionStore keeps browser sessions of authorization server,
+// browser holds only random id of the session in a cookie
+type SSOSessionStore interface {
+	CreateSession(ctx context.Context, id string, data *SSOSession, ttl time.Duration) error
+	GetSession(ctx context.Context, id string) (*SSOSession, error)
+	DeleteSession(ctx context.Context, id string) error
+}
+
+type ssoSessionStore struct {
+	rdb *redis.Client
+}
+
+// SSOSession remembers user signed in with upstream provider during /oauth/authorize,
+// it is valid only while user session it was created with is not signed out
+type SSOSession struct {
+	UserID    int `json:"user_id"`
+	SessionID int `json:"session_id"`
+	// upstream provider user signed in with
+	Provider  string    `json:"provider"`
+	CreatedAt time.Time `json:"created_at"`
+	// amr of upstream id token, empty when provider does not report it
+	AMR []string `json:"amr,omitempty"`
+}
+
+func NewSSOSessionStore(rdb *redis.Client) *ssoSessionStore {
+	return &ssoSessionStore{
+		rdb: rdb,
+	}
+}
+
+func (store *ssoSessionStore) CreateSession(ctx context.Context, id string, data *SSOSession, ttl time.Duration) error {
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("cannot encode sso session: %w", err)
+	}
+
+	created, err := store.rdb.SetNX(ctx, ssoSessionKeyPrefix+id, value, ttl).Result()
+
+	if err != nil {
+		return fmt.Errorf("cannot save sso session: %w", err)
+	}
+
+	if !created {
+		return fmt.Errorf("sso session already exists")
+	}
+
+	return nil
+}
+
+func (store *ssoSessionStore) GetSession(ctx context.Context, id string) (*SSOSession, error) {
+	value, err := store.rdb.Get(ctx, ssoSessionKeyPrefix+id).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("sso session not found")
+		}
+
+		return nil, fmt.Errorf("cannot get sso session: %w", err)
+	}
+
+	var data SSOSession
+	err = json.Unmarshal(value, &data)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode sso session: %w", err)
+	}
+
+	return &data, nil
+}
+
+func (store *ssoSessionStore) DeleteSession(ctx context.Context, id string) error {
+	err := store.rdb.Del(ctx, ssoSessionKeyPrefix+id).Err()
+
+	if err != nil {
+		return fmt.Errorf("cannot delete sso session: %w", err)
+	}
+
+	return nil
+}
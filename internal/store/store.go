@@ -1,6 +1,22 @@
 package store
 
+import "errors"
+
+// ErrNotFound is returned when no record matches filters,
+// callers tell it apart from database failures with errors.Is
+var ErrNotFound = errors.New("record not found")
+
 type Store struct {
-	User    UserStore
-	Session SessionStore
+	User         UserStore
+	Identity     IdentityStore
+	Token        TokenStore
+	Session      SessionStore
+	OAuthState   OAuthStateStore
+	ExchangeCode ExchangeCodeStore
+
+	OAuthClient       OAuthClientStore
+	AuthorizationCode AuthorizationCodeStore
+	SSOSession        SSOSessionStore
+	DeviceCode        DeviceCodeStore
+	RevokedToken      RevokedTokenStore
 }
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oauth-go/pkg/securestring"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrTokenNotFound = errors.New("provider token not found")
+
+// TokenStore keeps upstream provider tokens of identities,
+// tokens are encrypted before saving and decrypted after reading
+type TokenStore interface {
+	SaveToken(ctx context.Context, dto *ProviderTokenDto) (*ProviderToken, error)
+	GetTokenBy(ctx context.Context, filters map[string]any) (*ProviderToken, error)
+	DeleteTokensBy(ctx context.Context, filters map[string]any) error
+}
+
+type tokenStore struct {
+	db        *pgxpool.Pool
+	secretKey []byte
+}
+
+type ProviderToken struct {
+	ID           int        `db:"id" json:"-"`
+	IdentityID   int        `db:"identity_id" json:"identity_id"`
+	UserID       int        `db:"user_id" json:"user_id"`
+	Provider     string     `db:"provider" json:"provider"`
+	AccessToken  string     `db:"access_token" json:"-"`
+	RefreshToken *string    `db:"refresh_token" json:"-"`
+	TokenType    string     `db:"token_type" json:"token_type"`
+	ExpiresAt    *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+type ProviderTokenDto struct {
+	IdentityID int
+	UserID     int
+	Provider   string
+	// plain tokens, empty refresh token keeps previously stored one
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	ExpiresAt    time.Time
+}
+
+func NewTokenStore(db *pgxpool.Pool, secretKey []byte) *tokenStore {
+	return &tokenStore{
+		db:        db,
+		secretKey: secretKey,
+	}
+}
+
+func (store *tokenStore) decrypt(token *ProviderToken) error {
+	accessToken, err := securestring.Decrypt(store.secretKey, token.AccessToken)
+
+	if err != nil {
+		return fmt.Errorf("cannot decrypt access token: %w", err)
+	}
+
+	token.AccessToken = accessToken
+
+	if token.RefreshToken != nil {
+		refreshToken, err := securestring.Decrypt(store.secretKey, *token.RefreshToken)
+
+		if err != nil {
+			return fmt.Errorf("cannot decrypt refresh token: %w", err)
+		}
+
+		token.RefreshToken = &refreshToken
+	}
+
+	return nil
+}
+
+// SaveToken inserts or replaces token of identity
+func (store *tokenStore) SaveToken(ctx context.Context, dto *ProviderTokenDto) (*ProviderToken, error) {
+	accessToken, err := securestring.Encrypt(store.secretKey, dto.AccessToken)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt access token: %w", err)
+	}
+
+	var refreshToken any
+
+	if dto.RefreshToken != "" {
+		refreshToken, err = securestring.Encrypt(store.secretKey, dto.RefreshToken)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot encrypt refresh token: %w", err)
+		}
+	}
+
+	var expiresAt any
+
+	if !dto.ExpiresAt.IsZero() {
+		expiresAt = dto.ExpiresAt
+	}
+
+	sql, _, _ := goqu.Insert("user_identity_tokens").
+		Rows(goqu.Record{
+			"identity_id":   dto.IdentityID,
+			"user_id":       dto.UserID,
+			"provider":      dto.Provider,
+			"access_token":  accessToken,
+			"refresh_token": refreshToken,
+			"token_type":    dto.TokenType,
+			"expires_at":    expiresAt,
+		}).
+		OnConflict(goqu.DoUpdate("identity_id", goqu.Record{
+			"access_token":  goqu.L("EXCLUDED.access_token"),
+			"refresh_token": goqu.L("COALESCE(EXCLUDED.refresh_token, user_identity_tokens.refresh_token)"),
+			"token_type":    goqu.L("EXCLUDED.token_type"),
+			"expires_at":    goqu.L("EXCLUDED.expires_at"),
+			"updated_at":    time.Now(),
+		})).
+		Returning("*").ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	token, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[ProviderToken])
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	err = store.decrypt(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (store *tokenStore) GetTokenBy(ctx context.Context, filters map[string]any) (*ProviderToken, error) {
+	query := goqu.From("user_identity_tokens")
+
+	for key, value := range filters {
+		query = query.Where(goqu.I(key).Eq(value))
+	}
+
+	sql, _, _ := query.ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	token, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[ProviderToken])
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrTokenNotFound, filters)
+		}
+
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	err = store.decrypt(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (store *tokenStore) DeleteTokensBy(ctx context.Context, filters map[string]any) error {
+	query := goqu.Delete("user_identity_tokens")
+
+	for key, value := range filters {
+		query = query.Where(goqu.I(key).Eq(value))
+	}
+
+	sql, _, _ := query.ToSQL()
+
+	_, err := store.db.Exec(ctx, sql)
+
+	if err != nil {
+		return fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -14,6 +15,8 @@ import (
 type UserStore interface {
 	CreateUser(ctx context.Context, dto *UserDto) (*User, error)
 	GetUserBy(ctx context.Context, filters map[string]any) (*User, error)
+	UpdateUser(ctx context.Context, userID int, dto *UpdateUserDto) (*User, error)
+	DeleteUser(ctx context.Context, userID int) error
 }
 
 type userStore struct {
@@ -26,19 +29,32 @@ type User struct {
 	Email           string     `db:"email" json:"email"`
 	AvatarURL       *string    `db:"avatar_url" json:"avatar_url,omitempty"`
 	IsEmailVerified bool       `db:"is_email_verified" json:"is_email_verified"`
-	Provider        string     `db:"provider" json:"provider"`
-	ProviderUserID  string     `db:"provider_user_id" json:"-"`
 	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt       time.Time  `db:"updated_at" json:"-"`
 	DeletedAt       *time.Time `db:"deleted_at" json:"-"`
+	// granted by claims mapping rules on last login
+	Roles      []string          `db:"roles" json:"roles"`
+	Attributes map[string]string `db:"attributes" json:"attributes"`
 }
 
+// UserDto creates user together with the identity used to sign up
 type UserDto struct {
 	Name           string `db:"name" json:"name,omitempty"`
 	Email          string `db:"email" json:"email"`
 	AvatarURL      string `db:"avatar_url" json:"avatar_url,omitempty"`
 	Provider       string
 	ProviderUserID string
+	// email verification status reported by provider
+	IsEmailVerified bool
+}
+
+// UpdateUserDto updates only non nil fields, empty roles and attributes clear them
+type UpdateUserDto struct {
+	Name            *string
+	AvatarURL       *string
+	IsEmailVerified *bool
+	Roles           []string
+	Attributes      map[string]string
 }
 
 func NewUserStore(db *pgxpool.Pool) *userStore {
@@ -48,17 +64,23 @@ func NewUserStore(db *pgxpool.Pool) *userStore {
 }
 
 func (store *userStore) CreateUser(ctx context.Context, dto *UserDto) (*User, error) {
+	tx, err := store.db.Begin(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
 	sql, _, _ := goqu.Insert("users").
 		Rows(goqu.Record{
 			"name":              dto.Name,
 			"email":             dto.Email,
 			"avatar_url":        dto.AvatarURL,
-			"is_email_verified": true,
-			"provider":          dto.Provider,
-			"provider_user_id":  dto.ProviderUserID,
+			"is_email_verified": dto.IsEmailVerified,
 		}).Returning("*").ToSQL()
 
-	rows, err := store.db.Query(ctx, sql)
+	rows, err := tx.Query(ctx, sql)
 	if err != nil {
 		return nil, fmt.Errorf("query execution failed: %w", err)
 	}
@@ -68,6 +90,25 @@ func (store *userStore) CreateUser(ctx context.Context, dto *UserDto) (*User, er
 		return nil, fmt.Errorf("query execution failed: %w", err)
 	}
 
+	sql, _, _ = goqu.Insert("user_identities").
+		Rows(identityRecord(&UserIdentityDto{
+			UserID:         user.ID,
+			Provider:       dto.Provider,
+			ProviderUserID: dto.ProviderUserID,
+			Email:          dto.Email,
+			EmailVerified:  dto.IsEmailVerified,
+		})).ToSQL()
+
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot commit transaction: %w", err)
+	}
+
 	return user, nil
 }
 
@@ -92,7 +133,7 @@ func (store *userStore) GetUserBy(ctx context.Context, filters map[string]any) (
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, fmt.Errorf("user not found: %s", filters)
+			return nil, fmt.Errorf("%w: user %s", ErrNotFound, filters)
 		}
 
 		return nil, fmt.Errorf("query execution failed: %w", err)
@@ -100,3 +141,89 @@ func (store *userStore) GetUserBy(ctx context.Context, filters map[string]any) (
 
 	return user, nil
 }
+
+func (store *userStore) UpdateUser(ctx context.Context, userID int, dto *UpdateUserDto) (*User, error) {
+	record := goqu.Record{"updated_at": time.Now()}
+
+	if dto.Name != nil {
+		record["name"] = *dto.Name
+	}
+
+	if dto.AvatarURL != nil {
+		record["avatar_url"] = *dto.AvatarURL
+	}
+
+	if dto.IsEmailVerified != nil {
+		record["is_email_verified"] = *dto.IsEmailVerified
+	}
+
+	if dto.Roles != nil {
+		roles, _ := json.Marshal(dto.Roles)
+		record["roles"] = string(roles)
+	}
+
+	if dto.Attributes != nil {
+		attributes, _ := json.Marshal(dto.Attributes)
+		record["attributes"] = string(attributes)
+	}
+
+	sql, _, _ := goqu.Update("users").
+		Set(record).
+		Where(goqu.I("id").Eq(userID), goqu.I("deleted_at").Is(nil)).
+		Returning("*").ToSQL()
+
+	rows, err := store.db.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	user, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[User])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("user not found: %d", userID)
+		}
+
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+
+	return user, nil
+}
+
+// DeleteUser soft deletes user with sessions and removes linked identities
+// together with their provider tokens, so the same identities can sign up again
+func (store *userStore) DeleteUser(ctx context.Context, userID int) error {
+	tx, err := store.db.Begin(ctx)
+
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+
+	queries := []interface{ ToSQL() (string, []any, error) }{
+		goqu.Update("users").
+			Set(goqu.Record{"deleted_at": now}).
+			Where(goqu.I("id").Eq(userID), goqu.I("deleted_at").Is(nil)),
+		goqu.Update("user_sessions").
+			Set(goqu.Record{"deleted_at": now}).
+			Where(goqu.I("user_id").Eq(userID), goqu.I("deleted_at").Is(nil)),
+		goqu.Delete("user_identity_tokens").
+			Where(goqu.I("user_id").Eq(userID)),
+		goqu.Delete("user_identities").
+			Where(goqu.I("user_id").Eq(userID)),
+	}
+
+	for _, query := range queries {
+		sql, _, _ := query.ToSQL()
+
+		_, err = tx.Exec(ctx, sql)
+
+		if err != nil {
+			return fmt.Errorf("query execution failed: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -1,5 +1,57 @@
 package types
 
+const (
+	// provider profile overwrites user fields on each login
+	ProfileSyncAlways = "always"
+	// provider profile fills only empty user fields, user edits are kept
+	ProfileSyncFillEmpty = "fill_empty"
+)
+
+const (
+	// tokens are returned as json body of callback
+	LoginDeliveryJSON = "json"
+	// redirect to return_to with one-time code which frontend exchanges for tokens
+	LoginDeliveryCode = "code"
+	// redirect to return_to with tokens in url fragment
+	LoginDeliveryFragment = "fragment"
+	// tokens are set as HttpOnly cookies and user is redirected to return_to
+	LoginDeliveryCookie = "cookie"
+)
+
+var LoginDeliveryModes = []string{LoginDeliveryJSON, LoginDeliveryCode, LoginDeliveryFragment, LoginDeliveryCookie}
+
+// LoginDeliverySSO sets authorization server session cookie and continues /oauth/authorize,
+// it is used internally and cannot be selected with ?delivery=
+const LoginDeliverySSO = "sso"
+
+const (
+	// spa and native apps which cannot keep a secret, PKCE is required
+	OAuthClientPublic = "public"
+	// server side apps authenticated with client secret
+	OAuthClientConfidential = "confidential"
+)
+
+var OAuthClientTypes = []string{OAuthClientPublic, OAuthClientConfidential}
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	// service to service access without user, confidential clients only
+	GrantClientCredentials = "client_credentials"
+	// devices without browser, user approves them on another device (RFC 8628)
+	GrantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// GrantTypes are grants which can be allowed for oauth client
+var GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials, GrantDeviceCode}
+
+const (
+	// OpenID Connect scopes, id_token is issued only when openid scope is granted
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
 type AppConfig struct {
 	AppPort     string `env:"APP_PORT" env_default:"8080"`
 	AppHost     string `env:"APP_HOST" env_default:"localhost"`
@@ -16,11 +68,87 @@ type AppConfig struct {
 
 	JwtSecret string `env:"JWT_SECRET"`
 
-	GoogleClientId     string `env:"GOOGLE_CLIENT_ID"`
-	GoogleClientSecret string `env:"GOOGLE_CLIENT_SECRET"`
-	GoogleRedirectURL  string `env:"GOOGLE_REDIRECT_URL"`
+	// base64 encoded 32 bytes AES key used to encrypt provider tokens at rest
+	TokenEncryptionKey string `env:"TOKEN_ENCRYPTION_KEY"`
+
+	// how provider profile fields are synced to user on login, always or fill_empty
+	ProfileSyncPolicy string `env:"PROFILE_SYNC_POLICY" env_default:"fill_empty"`
+
+	// default way tokens are delivered after sign-in, can be changed per sign-in with ?delivery=
+	LoginDelivery string `env:"LOGIN_DELIVERY" env_default:"json"`
+
+	// json file with rules mapping provider data to user roles and attributes
+	ClaimsMappingFile string `env:"CLAIMS_MAPPING_FILE" env_optional:"true"`
+
+	// serves fake OpenID Connect provider at /dev/idp, development only
+	DevIDPEnabled bool `env:"DEV_IDP_ENABLED" env_optional:"true"`
+	// public url of fake provider, defaults to http://APP_HOST:APP_PORT/dev/idp
+	DevIDPIssuer string `env:"DEV_IDP_ISSUER" env_optional:"true"`
+	// json file with fixture users of fake provider
+	DevIDPUsersFile string `env:"DEV_IDP_USERS_FILE" env_optional:"true"`
+
+	// keys of internal services allowed to call /internal api,
+	// internal api is disabled when empty
+	InternalAPIKeys []string `env:"INTERNAL_API_KEYS" env_optional:"true"`
+
+	// public url of OAuth 2.0 authorization server endpoints,
+	// defaults to http://APP_HOST:APP_PORT/api/v1/oauth
+	OAuthIssuer string `env:"OAUTH_ISSUER" env_optional:"true"`
+	// pem RSA private key signing tokens issued to oauth clients,
+	// random key is generated on start when empty
+	OAuthSigningKeyFile string `env:"OAUTH_SIGNING_KEY_FILE" env_optional:"true"`
+
+	// frontend urls which are allowed as return_to after sign-in,
+	// matched by scheme, host and path prefix
+	AllowedReturnURLs []string `env:"ALLOWED_RETURN_URLS" env_optional:"true"`
+
+	// timeout of a single request to provider in seconds
+	UpstreamTimeout int `env:"UPSTREAM_TIMEOUT" env_default:"10"`
+	// how many times failed provider GET requests are retried, token requests are never retried
+	UpstreamRetries int `env:"UPSTREAM_RETRIES" env_default:"2"`
+	// consecutive provider failures which open its circuit breaker
+	UpstreamBreakerThreshold int `env:"UPSTREAM_BREAKER_THRESHOLD" env_default:"5"`
+	// seconds while open circuit rejects requests before a probe request is let through
+	UpstreamBreakerCooldown int `env:"UPSTREAM_BREAKER_COOLDOWN" env_default:"30"`
+
+	// list of enabled oauth providers, each provider is configured
+	// with <NAME>_CLIENT_ID, <NAME>_CLIENT_SECRET and <NAME>_REDIRECT_URL
+	OAuthProviders []string `env:"OAUTH_PROVIDERS" env_default:"google,github"`
+}
+
+// ProviderConfig holds credentials of a single oauth provider,
+// env variables are prefixed with provider name, e.g. GOOGLE_CLIENT_ID
+type ProviderConfig struct {
+	// registered provider type, defaults to provider name,
+	// allows to enable several providers of the same type, e.g. KEYCLOAK_TYPE=oidc
+	Type string `env:"TYPE" env_optional:"true"`
+
+	ClientID string `env:"CLIENT_ID"`
+	// required unless provider generates its own secret, e.g. apple
+	ClientSecret string `env:"CLIENT_SECRET" env_optional:"true"`
+	RedirectURL  string `env:"REDIRECT_URL"`
+	// extra redirect urls registered in provider app as name=url pairs, selected with ?redirect=name on sign-in,
+	// e.g. admin=https://admin.example.com/api/v1/auth/callback/github
+	RedirectURLs []string `env:"REDIRECT_URLS" env_optional:"true"`
+
+	// OpenID Connect issuer, used by providers with discovery
+	IssuerURL string `env:"ISSUER_URL" env_optional:"true"`
+
+	// self-hosted instance url, e.g. https://gitlab.example.com,
+	// used by github (enterprise server), gitlab and gitea
+	BaseURL string `env:"BASE_URL" env_optional:"true"`
+
+	// client ids of native apps which id tokens are accepted by /auth/token/:provider,
+	// e.g. ios bundle id for apple or android client id for google
+	NativeClientIDs []string `env:"NATIVE_CLIENT_IDS" env_optional:"true"`
+
+	// extra scopes which can be requested per sign-in, e.g. GITHUB_ALLOWED_SCOPES=repo
+	AllowedScopes []string `env:"ALLOWED_SCOPES" env_optional:"true"`
+
+	// only users with verified email of these domains can sign in, any domain when empty
+	AllowedEmailDomains []string `env:"ALLOWED_EMAIL_DOMAINS" env_optional:"true"`
 
-	GithubClientId     string `env:"GITHUB_CLIENT_ID"`
-	GithubClientSecret string `env:"GITHUB_CLIENT_SECRET"`
-	GithubRedirectURL  string `env:"GITHUB_REDIRECT_URL"`
+	// env variables prefix of provider, e.g. GOOGLE_,
+	// lets providers parse their own settings with configurator.Parse
+	EnvPrefix string
 }
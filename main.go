@@ -41,6 +41,9 @@ func main() {
 
 	authController := controllers.NewAuthController(app)
 	healthController := controllers.NewHelathController(app)
+	identityController := controllers.NewIdentityController(app)
+	internalController := controllers.NewInternalController(app)
+	oauthController := controllers.NewOAuthController(app)
 
 	api := app.Router.Group("/api/v1")
 
@@ -48,10 +51,46 @@ func main() {
 
 	api.GET("/auth/sign-in/:provider", authController.SignIn)
 	api.GET("/auth/callback/:provider", authController.HandleCallback)
+	api.POST("/auth/callback/:provider", authController.HandleCallback)
+	api.POST("/auth/token/:provider", authController.SignInWithIDToken)
+	api.POST("/auth/exchange", authController.ExchangeCode)
 	api.GET("/auth/me", middleware.AuthMiddleware(app.Store, app.Services, app.Logger), authController.GetMe)
+	api.DELETE("/auth/me", middleware.AuthMiddleware(app.Store, app.Services, app.Logger), authController.DeleteMe)
 	api.POST("/auth/refresh", authController.RefreshToken)
 	api.GET("/auth/sign-out", middleware.AuthMiddleware(app.Store, app.Services, app.Logger), authController.SignOut)
 
+	api.GET("/auth/identities", middleware.AuthMiddleware(app.Store, app.Services, app.Logger), identityController.GetIdentities)
+	api.GET("/auth/identities/link/:provider", middleware.AuthMiddleware(app.Store, app.Services, app.Logger), identityController.LinkIdentity)
+	api.DELETE("/auth/identities/:id", middleware.AuthMiddleware(app.Store, app.Services, app.Logger), identityController.UnlinkIdentity)
+
+	api.GET("/oauth/authorize", oauthController.Authorize)
+	api.POST("/oauth/authorize", oauthController.AuthorizeConsent)
+	api.POST("/oauth/token", oauthController.Token)
+	api.GET("/oauth/userinfo", oauthController.UserInfo)
+	api.POST("/oauth/userinfo", oauthController.UserInfo)
+	api.GET("/oauth/jwks", oauthController.JWKS)
+	api.GET("/oauth/.well-known/openid-configuration", oauthController.OpenIDConfiguration)
+	api.POST("/oauth/device_authorization", oauthController.DeviceAuthorization)
+	api.GET("/oauth/device", oauthController.DevicePage)
+	api.POST("/oauth/device", oauthController.ApproveDevice)
+	api.POST("/oauth/introspect", oauthController.Introspect)
+	api.POST("/oauth/revoke", oauthController.Revoke)
+
+	internal := api.Group("/internal", middleware.InternalAuthMiddleware(app.Config.InternalAPIKeys, app.Logger))
+
+	internal.GET("/users/:id/tokens/:provider", internalController.GetProviderToken)
+	internal.GET("/providers/stats", internalController.GetProviderStats)
+	internal.POST("/oauth/clients", internalController.CreateOAuthClient)
+	internal.GET("/oauth/clients", internalController.GetOAuthClients)
+	internal.DELETE("/oauth/clients/:client_id", internalController.DeleteOAuthClient)
+
+	if app.Config.DevIDPEnabled {
+		if err := app.MountDevIDP(); err != nil {
+			logger.Error("cannot mount fake identity provider", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	docs.SwaggerInfo.BasePath = "/api/v1"
 	app.Router.GET("/swagger/*any", swagger.WrapHandler(files.Handler))
 
@@ -0,0 +1,35 @@
+BEGIN;
+
+ALTER TABLE users ADD COLUMN provider TEXT;
+ALTER TABLE users ADD COLUMN provider_user_id TEXT;
+
+UPDATE users SET
+  provider = identity.provider,
+  provider_user_id = identity.provider_user_id
+FROM (
+  SELECT DISTINCT ON (user_id) user_id, provider, provider_user_id
+  FROM user_identities
+  ORDER BY user_id, created_at
+) AS identity
+WHERE users.id = identity.user_id;
+
+-- users without any identity have no provider to restore, they are never deleted
+-- here, migration stops until they are linked to a provider or removed manually
+DO $$
+DECLARE
+  missing BIGINT;
+BEGIN
+  SELECT count(*) INTO missing FROM users WHERE provider IS NULL;
+
+  IF missing > 0 THEN
+    RAISE EXCEPTION 'cannot restore users.provider: % users have no identity', missing;
+  END IF;
+END $$;
+
+ALTER TABLE users ALTER COLUMN provider SET NOT NULL;
+ALTER TABLE users ALTER COLUMN provider_user_id SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_provider_provider_user_id_key UNIQUE (provider, provider_user_id);
+
+DROP TABLE user_identities;
+
+COMMIT;
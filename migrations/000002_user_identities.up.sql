@@ -0,0 +1,25 @@
+BEGIN;
+
+CREATE TABLE user_identities (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+  -- Identity
+  provider TEXT NOT NULL,
+  provider_user_id TEXT NOT NULL,
+  email TEXT,
+  UNIQUE (provider, provider_user_id),
+
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities (user_id);
+
+INSERT INTO user_identities (user_id, provider, provider_user_id, email, created_at)
+SELECT id, provider, provider_user_id, email, created_at FROM users;
+
+ALTER TABLE users DROP COLUMN provider;
+ALTER TABLE users DROP COLUMN provider_user_id;
+
+COMMIT;
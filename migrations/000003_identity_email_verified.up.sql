@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE user_identities ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE user_identity_tokens;
+
+COMMIT;
@@ -0,0 +1,21 @@
+BEGIN;
+
+CREATE TABLE user_identity_tokens (
+  id BIGSERIAL PRIMARY KEY,
+  identity_id BIGINT NOT NULL UNIQUE REFERENCES user_identities(id) ON DELETE CASCADE,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  provider TEXT NOT NULL,
+
+  -- Tokens, encrypted with TOKEN_ENCRYPTION_KEY
+  access_token TEXT NOT NULL,
+  refresh_token TEXT,
+  token_type TEXT NOT NULL,
+  expires_at TIMESTAMP,
+
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_identity_tokens_user_id ON user_identity_tokens (user_id, provider);
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE user_identities DROP COLUMN scopes;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- space separated scopes granted by provider
+ALTER TABLE user_identities ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE users DROP COLUMN attributes;
+ALTER TABLE users DROP COLUMN roles;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- roles and attributes granted by claims mapping rules on last login
+ALTER TABLE users ADD COLUMN roles JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE users ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE oauth_clients;
+
+COMMIT;
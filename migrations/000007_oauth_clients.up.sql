@@ -0,0 +1,21 @@
+BEGIN;
+
+-- applications which sign users in with this service acting as OAuth 2.0 authorization server
+CREATE TABLE oauth_clients (
+  id BIGSERIAL PRIMARY KEY,
+  client_id TEXT NOT NULL UNIQUE,
+  -- sha256 of client secret, NULL for public clients
+  client_secret_hash TEXT,
+  name TEXT NOT NULL,
+  -- public (spa, native app) or confidential (server side app)
+  client_type TEXT NOT NULL,
+  redirect_uris JSONB NOT NULL DEFAULT '[]',
+  grant_types JSONB NOT NULL DEFAULT '[]',
+  scopes JSONB NOT NULL DEFAULT '[]',
+
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  deleted_at TIMESTAMP DEFAULT NULL
+);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE user_identities DROP COLUMN attributes;
+ALTER TABLE user_identities DROP COLUMN roles;
+
+COMMIT;
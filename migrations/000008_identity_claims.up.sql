@@ -0,0 +1,8 @@
+BEGIN;
+
+-- roles and attributes granted by claims mapping rules on last login with the identity,
+-- user roles are merged from all linked identities
+ALTER TABLE user_identities ADD COLUMN roles JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE user_identities ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+
+COMMIT;
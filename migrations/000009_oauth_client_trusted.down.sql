@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE oauth_clients DROP COLUMN trusted;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- first-party clients authorize silently, users approve other clients on consent page
+ALTER TABLE oauth_clients ADD COLUMN trusted BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
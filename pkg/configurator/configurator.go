@@ -5,17 +5,20 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 const (
-	ENV_TAG         = "env"
-	ENV_DEFAULT_TAG = "env_default"
+	ENV_TAG          = "env"
+	ENV_DEFAULT_TAG  = "env_default"
+	ENV_OPTIONAL_TAG = "env_optional"
 )
 
 type Options struct {
-	File string
+	File   string
+	Prefix string
 }
 
 func loadEnvFile(file string) error {
@@ -36,12 +39,14 @@ func loadEnvFile(file string) error {
 	return nil
 }
 
-func getEnvValue(key, defaultValue string) (string, error) {
+func getEnvValue(key, defaultValue string, optional bool) (string, error) {
 	envValue := os.Getenv(key)
 
 	if envValue == "" {
 		if defaultValue != "" {
 			return defaultValue, nil
+		} else if optional {
+			return "", nil
 		} else {
 			return "", fmt.Errorf("missing required environment variable: %s", key)
 		}
@@ -54,9 +59,24 @@ func createTransformationError(typ string, tag string, err error) error {
 	return fmt.Errorf("invalid %s value for %s: %v", typ, tag, err)
 }
 
+func splitList(value string) []string {
+	list := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
 // Load parses environment variables into the provided struct based on tags.
-// It supports default values using the `env_default` tag.
-// Supported types are: string, int, float64, and bool.
+// It supports default values using the `env_default` tag,
+// fields tagged with `env_optional:"true"` are left empty when not set.
+// Supported types are: string, int, float64, bool and []string (comma separated).
 //
 // Example:
 //
@@ -78,6 +98,17 @@ func Load(config any, options *Options) error {
 		return fmt.Errorf("error loading env file: %w", err)
 	}
 
+	return Parse(config, options.Prefix)
+}
+
+// Parse fills the provided struct from already loaded environment variables.
+// Every `env` tag is prefixed with prefix, so the same struct can be reused
+// for several config sections, e.g. GOOGLE_CLIENT_ID and GITHUB_CLIENT_ID.
+func Parse(config any, prefix string) error {
+	if config == nil {
+		return fmt.Errorf("config is nil %v", config)
+	}
+
 	value := reflect.ValueOf(config)
 
 	if value.Kind() == reflect.Ptr {
@@ -88,13 +119,24 @@ func Load(config any, options *Options) error {
 		fieldType := value.Type().Field(i)
 		fieldTag := fieldType.Tag.Get(ENV_TAG)
 		defaultValue := fieldType.Tag.Get(ENV_DEFAULT_TAG)
+		optional := fieldType.Tag.Get(ENV_OPTIONAL_TAG) == "true"
+
+		if fieldTag == "" {
+			continue
+		}
+
+		fieldTag = prefix + fieldTag
 
-		envValue, err := getEnvValue(fieldTag, defaultValue)
+		envValue, err := getEnvValue(fieldTag, defaultValue, optional)
 
 		if err != nil {
 			return fmt.Errorf("error getting env value for field %s: %v", fieldTag, err)
 		}
 
+		if envValue == "" {
+			continue
+		}
+
 		fieldVal := value.Field(i)
 
 		switch fieldType.Type.Kind() {
@@ -121,10 +163,16 @@ func Load(config any, options *Options) error {
 			}
 
 			fieldVal.SetBool(boolValue)
+		case reflect.Slice:
+			if fieldType.Type.Elem().Kind() != reflect.String {
+				return fmt.Errorf("unsupported field type for %s", fieldTag)
+			}
+
+			fieldVal.Set(reflect.ValueOf(splitList(envValue)))
 		default:
 			return fmt.Errorf("unsupported field type for %s", fieldTag)
 		}
 	}
 
-	return err
+	return nil
 }
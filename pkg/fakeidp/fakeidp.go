@@ -0,0 +1,431 @@
+// Package fakeidp is an in-memory OpenID Connect provider with fixture users
+// for local development and tests, it must never be enabled in production
+package fakeidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	keyID    = "fakeidp"
+	codeTTL  = 5 * time.Minute
+	tokenTTL = time.Hour
+)
+
+type User struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+}
+
+// DefaultUsers are used when no fixtures are configured
+var DefaultUsers = []User{
+	{ID: "1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"},
+	{ID: "2", Email: "bob@example.com", EmailVerified: false, Name: "Bob"},
+}
+
+type authorizationCode struct {
+	user          User
+	clientID      string
+	redirectURI   string
+	nonce         string
+	scope         string
+	codeChallenge string
+	authTime      time.Time
+	expiresAt     time.Time
+}
+
+type grant struct {
+	user      User
+	clientID  string
+	scope     string
+	expiresAt time.Time
+}
+
+type Server struct {
+	issuer string
+	users  []User
+	key    *rsa.PrivateKey
+
+	mu            sync.Mutex
+	codes         map[string]*authorizationCode
+	accessTokens  map[string]*grant
+	refreshTokens map[string]*grant
+}
+
+// New creates provider which serves endpoints under issuer url
+func New(issuer string, users []User) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate signing key: %w", err)
+	}
+
+	if len(users) == 0 {
+		users = DefaultUsers
+	}
+
+	return &Server{
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		users:         users,
+		key:           key,
+		codes:         map[string]*authorizationCode{},
+		accessTokens:  map[string]*grant{},
+		refreshTokens: map[string]*grant{},
+	}, nil
+}
+
+// NewTestServer starts provider on a local httptest server,
+// its url is the issuer to configure the oidc provider with
+func NewTestServer(users []User) (*httptest.Server, error) {
+	server, err := New("", users)
+
+	if err != nil {
+		return nil, err
+	}
+
+	testServer := httptest.NewServer(server.Handler())
+	server.issuer = testServer.URL
+
+	return testServer, nil
+}
+
+// LoadUsers reads fixture users from json file
+func LoadUsers(path string) ([]User, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fixture users: %w", err)
+	}
+
+	var users []User
+	err = json.Unmarshal(data, &users)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse fixture users: %w", err)
+	}
+
+	return users, nil
+}
+
+// Handler serves discovery, authorize, token, userinfo and jwks endpoints,
+// it has to be mounted so that requests to issuer url reach it without prefix
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", server.discovery)
+	mux.HandleFunc("GET /authorize", server.authorize)
+	mux.HandleFunc("POST /token", server.token)
+	mux.HandleFunc("GET /userinfo", server.userinfo)
+	mux.HandleFunc("GET /jwks", server.jwks)
+
+	return mux
+}
+
+func randomString() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func (server *Server) findUser(id string) (User, bool) {
+	for _, user := range server.users {
+		if user.ID == id || user.Email == id {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
+
+func (server *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                server.issuer,
+		"authorization_endpoint":                server.issuer + "/authorize",
+		"token_endpoint":                        server.issuer + "/token",
+		"userinfo_endpoint":                     server.issuer + "/userinfo",
+		"jwks_uri":                              server.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+var chooserTemplate = template.Must(template.New("chooser").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake identity provider</title></head>
+<body>
+<h1>Sign in as</h1>
+<ul>
+{{range .}}<li><a href="{{.URL}}">{{.User.Name}} &lt;{{.User.Email}}&gt;</a>{{if not .User.EmailVerified}} (unverified email){{end}}</li>
+{{end}}</ul>
+</body>
+</html>`))
+
+// authorize shows fixture users chooser, user is selected with user or login_hint parameter
+func (server *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+
+	if err != nil || !redirectURI.IsAbs() || query.Get("client_id") == "" {
+		http.Error(w, "client_id and absolute redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("response_type") != "code" {
+		http.Error(w, "only response_type=code is supported", http.StatusBadRequest)
+		return
+	}
+
+	selected := query.Get("user")
+
+	if selected == "" {
+		selected = query.Get("login_hint")
+	}
+
+	user, ok := server.findUser(selected)
+
+	if !ok {
+		type choice struct {
+			User User
+			URL  string
+		}
+
+		choices := make([]choice, 0, len(server.users))
+
+		for _, user := range server.users {
+			choiceQuery := r.URL.Query()
+			choiceQuery.Set("user", user.ID)
+			choices = append(choices, choice{User: user, URL: "?" + choiceQuery.Encode()})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		chooserTemplate.Execute(w, choices)
+		return
+	}
+
+	challenge := query.Get("code_challenge")
+
+	if challenge != "" && query.Get("code_challenge_method") == "S256" {
+		challenge = "S256:" + challenge
+	}
+
+	code := randomString()
+
+	server.mu.Lock()
+	server.codes[code] = &authorizationCode{
+		user:          user,
+		clientID:      query.Get("client_id"),
+		redirectURI:   redirectURI.String(),
+		nonce:         query.Get("nonce"),
+		scope:         query.Get("scope"),
+		codeChallenge: challenge,
+		authTime:      time.Now(),
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	server.mu.Unlock()
+
+	callbackQuery := redirectURI.Query()
+	callbackQuery.Set("code", code)
+
+	if state := query.Get("state"); state != "" {
+		callbackQuery.Set("state", state)
+	}
+
+	redirectURI.RawQuery = callbackQuery.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// verifyChallenge checks PKCE verifier against challenge stored with the code
+func verifyChallenge(challenge string, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+
+	if s256, ok := strings.CutPrefix(challenge, "S256:"); ok {
+		hash := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(hash[:])
+		challenge = s256
+	}
+
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(verifier)) == 1
+}
+
+func (server *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "cannot parse form")
+		return
+	}
+
+	// any client is accepted, secret is only required to be sent
+	clientID, clientSecret, ok := r.BasicAuth()
+
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	if clientID == "" || clientSecret == "" {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "client credentials are required")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		server.mu.Lock()
+		code, ok := server.codes[r.PostForm.Get("code")]
+		delete(server.codes, r.PostForm.Get("code"))
+		server.mu.Unlock()
+
+		if !ok || time.Now().After(code.expiresAt) || code.clientID != clientID || code.redirectURI != r.PostForm.Get("redirect_uri") {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "code is invalid or expired")
+			return
+		}
+
+		if !verifyChallenge(code.codeChallenge, r.PostForm.Get("code_verifier")) {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "code verifier does not match")
+			return
+		}
+
+		server.issueTokens(w, code.user, clientID, code.scope, code.nonce, code.authTime)
+	case "refresh_token":
+		server.mu.Lock()
+		refresh, ok := server.refreshTokens[r.PostForm.Get("refresh_token")]
+		delete(server.refreshTokens, r.PostForm.Get("refresh_token"))
+		server.mu.Unlock()
+
+		if !ok || refresh.clientID != clientID {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+			return
+		}
+
+		server.issueTokens(w, refresh.user, clientID, refresh.scope, "", time.Now())
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code and refresh_token are supported")
+	}
+}
+
+func (server *Server) issueTokens(w http.ResponseWriter, user User, clientID string, scope string, nonce string, authTime time.Time) {
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"iss":            server.issuer,
+		"sub":            user.ID,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(tokenTTL).Unix(),
+		"auth_time":      authTime.Unix(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"name":           user.Name,
+		"picture":        user.Picture,
+	}
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+
+	signed, err := idToken.SignedString(server.key)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", "cannot sign id token")
+		return
+	}
+
+	accessToken := randomString()
+	refreshToken := randomString()
+
+	server.mu.Lock()
+	server.accessTokens[accessToken] = &grant{user: user, clientID: clientID, scope: scope, expiresAt: now.Add(tokenTTL)}
+	server.refreshTokens[refreshToken] = &grant{user: user, clientID: clientID, scope: scope}
+	server.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(tokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"id_token":      signed,
+		"scope":         scope,
+	})
+}
+
+func (server *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	server.mu.Lock()
+	grant, found := server.accessTokens[accessToken]
+	server.mu.Unlock()
+
+	if !ok || !found || time.Now().After(grant.expiresAt) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token", "access token is invalid or expired")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            grant.user.ID,
+		"email":          grant.user.Email,
+		"email_verified": grant.user.EmailVerified,
+		"name":           grant.user.Name,
+		"picture":        grant.user.Picture,
+	})
+}
+
+func (server *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	publicKey := server.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": keyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			},
+		},
+	})
+}
@@ -0,0 +1,57 @@
+package response
+
+import "net/http"
+
+// OAuthError is error response of OAuth 2.0 endpoints (RFC 6749 section 5.2),
+// off-the-shelf OAuth clients expect it instead of APIErrorResponse
+type OAuthError struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error" example:"invalid_request"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code
+}
+
+// WithDescription returns copy of error with human readable description
+func (e *OAuthError) WithDescription(description string) *OAuthError {
+	return &OAuthError{
+		Status:      e.Status,
+		Code:        e.Code,
+		Description: description,
+	}
+}
+
+func NewOAuthError(status int, code string) *OAuthError {
+	return &OAuthError{
+		Status: status,
+		Code:   code,
+	}
+}
+
+var (
+	OAuthInvalidRequest          = NewOAuthError(http.StatusBadRequest, "invalid_request")
+	OAuthInvalidClient           = NewOAuthError(http.StatusUnauthorized, "invalid_client")
+	OAuthInvalidGrant            = NewOAuthError(http.StatusBadRequest, "invalid_grant")
+	OAuthUnsupportedGrantType    = NewOAuthError(http.StatusBadRequest, "unsupported_grant_type")
+	OAuthUnauthorizedClient      = NewOAuthError(http.StatusBadRequest, "unauthorized_client")
+	OAuthAccessDenied            = NewOAuthError(http.StatusBadRequest, "access_denied")
+	OAuthUnsupportedResponseType = NewOAuthError(http.StatusBadRequest, "unsupported_response_type")
+	OAuthInvalidScope            = NewOAuthError(http.StatusBadRequest, "invalid_scope")
+	OAuthServerError             = NewOAuthError(http.StatusInternalServerError, "server_error")
+	OAuthTemporarilyUnavailable  = NewOAuthError(http.StatusServiceUnavailable, "temporarily_unavailable")
+	OAuthLoginRequired           = NewOAuthError(http.StatusBadRequest, "login_required")
+	OAuthConsentRequired         = NewOAuthError(http.StatusBadRequest, "consent_required")
+	// device polling errors of token endpoint (RFC 8628 section 3.5)
+	OAuthAuthorizationPending = NewOAuthError(http.StatusBadRequest, "authorization_pending")
+	OAuthSlowDown             = NewOAuthError(http.StatusBadRequest, "slow_down")
+	OAuthExpiredToken         = NewOAuthError(http.StatusBadRequest, "expired_token")
+	// bearer token errors of resource endpoints (RFC 6750 section 3.1)
+	OAuthInvalidToken      = NewOAuthError(http.StatusUnauthorized, "invalid_token")
+	OAuthInsufficientScope = NewOAuthError(http.StatusForbidden, "insufficient_scope")
+)
+
+func RespondOAuthError(c Context, err *OAuthError) {
+	c.JSON(err.Status, err)
+}
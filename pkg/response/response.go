@@ -40,6 +40,14 @@ var (
 	ErrInvalidInput        = NewError(http.StatusBadRequest, "INVALID_INPUT", "The input is invalid.")
 	ErrUnauthorized        = NewError(http.StatusUnauthorized, "UNAUTHORIZED", "You are not authorized to access this resource.")
 	ErrOAuth               = NewError(http.StatusBadRequest, "OAUTH_ERROR", "OAuth error.")
+	ErrIdentityLinked      = NewError(http.StatusConflict, "IDENTITY_ALREADY_LINKED", "This identity is already linked to another user.")
+	ErrLastIdentity        = NewError(http.StatusConflict, "LAST_IDENTITY", "The last linked identity cannot be removed.")
+	ErrAmbiguousIdentity   = NewError(http.StatusConflict, "AMBIGUOUS_IDENTITY", "The user has several identities at this provider, identity_id is required.")
+	ErrNoVerifiedEmail     = NewError(http.StatusBadRequest, "NO_VERIFIED_EMAIL", "Your provider account has no verified email address.")
+	ErrAccountExists       = NewError(http.StatusConflict, "ACCOUNT_EXISTS", "An account with this email already exists, sign in with its provider and link this identity.")
+	ErrTenantNotAllowed    = NewError(http.StatusForbidden, "TENANT_NOT_ALLOWED", "Your organization directory is not allowed to sign in.")
+	ErrAccessDenied        = NewError(http.StatusForbidden, "ACCESS_DENIED", "Your account is not allowed to sign in.")
+	ErrProviderUnavailable = NewError(http.StatusServiceUnavailable, "PROVIDER_UNAVAILABLE", "The identity provider is unavailable, try again later.")
 	ErrInternalServerError = NewError(http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", "Internal server error.")
 )
 